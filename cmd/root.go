@@ -4,20 +4,37 @@ import (
 	"errors"
 	"fmt"
 	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/data"
 	"github.com/cretz/systrument/remote"
+	"github.com/cretz/systrument/util"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 )
 
+// How long running commands get to stop after an interrupt before exiting anyway
+var InterruptGracePeriod = 10 * time.Second
+
 type RootCmd struct {
 	*cobra.Command
 	Verbose          bool
+	Stream           bool
 	ConfigFiles      []string
 	IsRemote         bool
 	ForceLocal       bool
 	OverrideLocalDir string
+	SchemaFiles      []string
+	AnswersFile      string
+	SetValues        []string
+	SetJSONValues    []string
+	Schemas          []*data.Schema
+	TemplateFuncs    template.FuncMap
 	Context          *context.Context
-	cleanedUp        bool
+	cleanUpOnce      sync.Once
 }
 
 func NewRootCmd(cmds ...Command) *RootCmd {
@@ -27,7 +44,7 @@ func NewRootCmd(cmds ...Command) *RootCmd {
 		Short: "Admin tool",
 		PersistentPreRun: func(childCmd *cobra.Command, args []string) {
 			if err := c.preRun(childCmd, args); err != nil {
-				fmt.Printf("Error: %v\n", err)
+				fmt.Printf("Error: %v\n", c.redact(err))
 				c.cleanUp()
 				os.Exit(-1)
 			}
@@ -37,29 +54,90 @@ func NewRootCmd(cmds ...Command) *RootCmd {
 		},
 	}
 	c.PersistentFlags().BoolVarP(&c.Verbose, "verbose", "v", false, "Verbose output")
-	c.PersistentFlags().StringSliceVarP(&c.ConfigFiles, "config", "c", nil, "Config file(s)")
+	c.PersistentFlags().BoolVar(&c.Stream, "stream", false, "Show command output as it happens")
+	c.PersistentFlags().StringSliceVarP(&c.ConfigFiles, "config", "c", nil, "Config file(s), directories, or globs")
 	c.PersistentFlags().BoolVar(&c.IsRemote, "is-remote", false, "If remote we ignore several things")
 	c.PersistentFlags().BoolVar(&c.ForceLocal, "force-local", false, "Never run remote regardless of config")
 	c.PersistentFlags().StringVar(&c.OverrideLocalDir, "override-local-dir", "", "The path to the main go file")
+	c.PersistentFlags().StringSliceVar(&c.SchemaFiles, "schema", nil, "JSON schema file(s) to validate the config against")
+	c.PersistentFlags().StringVar(&c.AnswersFile, "answers", "", "File of answers for prompts when not interactive")
+	c.PersistentFlags().StringArrayVar(&c.SetValues, "set", nil, "Config value override as key.path=value")
+	c.PersistentFlags().StringArrayVar(&c.SetJSONValues, "set-json", nil, "Config value override as key.path=json")
 
 	c.AddCommand(new(ShowConfigCmd))
+	c.AddCommand(&ValidateCmd{root: c})
 	for _, childCmd := range cmds {
 		c.AddCommand(childCmd)
 	}
 	return c
 }
 
+// Registers custom template functions available to config and resource templates. This must be
+// called before Execute and should be called the same way on local and remote (i.e. in main).
+func (r *RootCmd) RegisterTemplateFuncs(funcs template.FuncMap) {
+	if r.TemplateFuncs == nil {
+		r.TemplateFuncs = template.FuncMap{}
+	}
+	for key, val := range funcs {
+		r.TemplateFuncs[key] = val
+	}
+}
+
 func (r *RootCmd) remoteAllowed(childCmd *cobra.Command) bool {
-	return !r.ForceLocal && childCmd.Name() != "showconfig"
+	return !r.ForceLocal && childCmd.Name() != "showconfig" && childCmd.Name() != "validate"
+}
+
+// Showing config needs to work on invalid config and validating reports errors itself
+func (r *RootCmd) validationRequired(childCmd *cobra.Command) bool {
+	return childCmd.Name() != "showconfig" && childCmd.Name() != "validate"
+}
+
+func (r *RootCmd) validate(ctx *context.Context) ([]*data.ValidationError, error) {
+	schemas := append([]*data.Schema{}, r.Schemas...)
+	for _, file := range r.SchemaFiles {
+		// Via resources so the target host gets them from the controller
+		byts, err := ctx.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read schema file %v: %v", file, err)
+		}
+		schema, err := context.SchemaFromBytes(file, byts)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return ctx.Data.Validate(schemas...), nil
 }
 
 func (r *RootCmd) preRun(childCmd *cobra.Command, args []string) error {
 	if !r.IsRemote {
+		prompter := data.NewPrompter(nil)
+		if r.AnswersFile != "" {
+			answers, err := context.AnswersFromFile(r.AnswersFile)
+			if err != nil {
+				return err
+			}
+			prompter.Answers = answers
+		}
+		overrides, err := r.overrides()
+		if err != nil {
+			return err
+		}
 		// Here we send to the remote server if one is provided and we're not ignoring it
-		ctx, err := context.FromConfigFiles(r.ConfigFiles, r.Verbose, r.OverrideLocalDir)
+		ctx, err := context.FromConfigFiles(r.ConfigFiles, r.Verbose, r.OverrideLocalDir, prompter, overrides, r.TemplateFuncs)
 		if err != nil {
 			return fmt.Errorf("Unable to load from config files: %v", err)
 		}
+		ctx.StreamOutput = r.Stream
+		r.Context = ctx
+		r.handleSignals()
+		// Remote templates are merged on the target host which validates after, but anything else
+		// can fail before going there
+		if len(ctx.RemoteTemplates) == 0 {
+			if err := r.validateIfRequired(childCmd, ctx); err != nil {
+				return err
+			}
+		}
 		if r.remoteAllowed(childCmd) {
 			if remote, err := remote.RemoteIfPresent(ctx); err != nil {
 				return err
@@ -67,25 +145,104 @@ func (r *RootCmd) preRun(childCmd *cobra.Command, args []string) error {
 				if err := remote.RunRemotely(); err != nil {
 					return fmt.Errorf("Remote error: %v", err)
 				}
+				r.cleanUp()
 				os.Exit(0)
 			}
 		}
-		r.Context = ctx
+		// Not running remotely, so this is the target host unless we're just looking at config
+		if r.remoteAllowed(childCmd) || r.ForceLocal {
+			if len(ctx.RemoteTemplates) > 0 {
+				if err := ctx.ApplyRemoteTemplates(); err != nil {
+					return err
+				} else if err := r.validateIfRequired(childCmd, ctx); err != nil {
+					return err
+				}
+			}
+		}
 	} else {
 		if r.OverrideLocalDir == "" {
 			return errors.New("Must have --override-local-dir for remote")
 		}
-		ctx, err := context.FromRemoteStdPipe(r.Verbose, r.OverrideLocalDir)
+		ctx, err := context.FromRemoteStdPipe(r.Verbose, r.OverrideLocalDir, r.TemplateFuncs)
 		if err != nil {
 			return fmt.Errorf("Unable to begin remote command over std pipes: %v", err)
 		}
+		ctx.StreamOutput = r.Stream
 		r.Context = ctx
+		r.handleSignals()
+		if err := r.validateIfRequired(childCmd, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Errors can echo config values, so they're masked with the context's secrets once there is one
+func (r *RootCmd) redact(err error) string {
+	if r.Context == nil {
+		return err.Error()
+	}
+	return r.Context.Redact(err.Error())
+}
+
+func (r *RootCmd) validateIfRequired(childCmd *cobra.Command, ctx *context.Context) error {
+	if !r.validationRequired(childCmd) {
+		return nil
+	}
+	if errs, err := r.validate(ctx); err != nil {
+		return err
+	} else if len(errs) > 0 {
+		return fmt.Errorf("Invalid config:\n  %v", joinValidationErrors(errs, "\n  "))
 	}
 	return nil
 }
 
+// Cancels the context on interrupt or termination (including the SSH session closing when
+// remote), then cleans up and exits if the command hasn't already by the grace period or a second
+// signal
+func (r *RootCmd) handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigs
+		r.Context.Infof("Received %v, cancelling", sig)
+		r.Context.Cancel()
+		select {
+		case <-time.After(InterruptGracePeriod):
+		case <-sigs:
+		}
+		r.cleanUp()
+		os.Exit(-1)
+	}()
+}
+
+// Env vars first, then --set, then --set-json
+func (r *RootCmd) overrides() ([]*context.Override, error) {
+	overrides, err := context.EnvOverrides(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	for _, str := range r.SetValues {
+		o, err := context.ParseOverride(str, false)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	for _, str := range r.SetJSONValues {
+		o, err := context.ParseOverride(str, true)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+// Safe to call concurrently, only the first call cleans up
 func (r *RootCmd) cleanUp() {
-	if !r.cleanedUp {
+	r.cleanUpOnce.Do(func() {
+		util.FlushLineLogWriters()
 		// If we're remote we need to delete ourself
 		if r.IsRemote {
 			r.Context.Debugf("Removing self at %v", os.Args[0])
@@ -100,8 +257,7 @@ func (r *RootCmd) cleanUp() {
 				r.Context.Debugf("Unable to remove temp dir %v: %v", r.Context.TempDir, err)
 			}
 		}
-		r.cleanedUp = true
-	}
+	})
 }
 
 type Command interface {
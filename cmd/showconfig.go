@@ -7,20 +7,45 @@ import (
 	"github.com/spf13/cobra"
 )
 
-type ShowConfigCmd struct{}
+type ShowConfigCmd struct {
+	Explain string
+}
 
-func (_ *ShowConfigCmd) CmdInfo() *cobra.Command {
-	return &cobra.Command{
+func (s *ShowConfigCmd) CmdInfo() *cobra.Command {
+	cmd := &cobra.Command{
 		Use:   "showconfig",
 		Short: "Show configuration after applying all templates",
 	}
+	cmd.Flags().StringVar(&s.Explain, "explain", "", "Dotted key path to show the override chain for instead")
+	return cmd
 }
 
-func (_ *ShowConfigCmd) Run(ctx *context.Context) error {
+func (s *ShowConfigCmd) Run(ctx *context.Context) error {
+	if s.Explain != "" {
+		return s.explain(ctx)
+	}
 	byts, err := json.MarshalIndent(ctx.Data.Values, "", "  ")
 	if err != nil {
 		return fmt.Errorf("Unable to marshal JSON: %v", err)
 	}
+	// Secret values are masked by the logger
 	ctx.Logger.Infof("Config:\n%v", string(byts))
 	return nil
 }
+
+func (s *ShowConfigCmd) explain(ctx *context.Context) error {
+	sources := ctx.Data.SourcesOf(s.Explain)
+	if len(sources) == 0 {
+		return fmt.Errorf("No config values found for %v", s.Explain)
+	}
+	str := ""
+	for _, source := range sources {
+		byts, err := json.Marshal(source.Value)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal JSON: %v", err)
+		}
+		str += fmt.Sprintf("\n  %v %v = %v (%v)", source.Location(), source.Action, string(byts), source.Path)
+	}
+	ctx.Logger.Infof("Override chain for %v:%v", s.Explain, str)
+	return nil
+}
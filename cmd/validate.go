@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/data"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+type ValidateCmd struct {
+	root *RootCmd
+}
+
+func (_ *ValidateCmd) CmdInfo() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Validate configuration against the schema(s) after applying all templates",
+	}
+}
+
+func (v *ValidateCmd) Run(ctx *context.Context) error {
+	errs, err := v.root.validate(ctx)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Config has %v error(s):\n  %v", len(errs), joinValidationErrors(errs, "\n  "))
+	}
+	ctx.Infof("Config is valid")
+	return nil
+}
+
+func joinValidationErrors(errs []*data.ValidationError, sep string) string {
+	strs := make([]string, len(errs))
+	for i, err := range errs {
+		strs[i] = err.Error()
+	}
+	return strings.Join(strs, sep)
+}
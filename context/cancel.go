@@ -0,0 +1,41 @@
+package context
+
+import (
+	stdcontext "context"
+)
+
+func (c *Context) initCancel() {
+	c.cancelCtx, c.cancel = stdcontext.WithCancel(stdcontext.Background())
+}
+
+// Closed when the context is cancelled (e.g. on interrupt). Never closed for contexts not created
+// via FromConfigFiles or FromRemoteStdPipe.
+func (c *Context) Done() <-chan struct{} {
+	if c.cancelCtx == nil {
+		return nil
+	}
+	return c.cancelCtx.Done()
+}
+
+// Non-nil once cancelled
+func (c *Context) Err() error {
+	if c.cancelCtx == nil {
+		return nil
+	}
+	return c.cancelCtx.Err()
+}
+
+// Cancels everything running with this context. Safe to call multiple times.
+func (c *Context) Cancel() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// A standard library context cancelled along with this one
+func (c *Context) StdContext() stdcontext.Context {
+	if c.cancelCtx == nil {
+		return stdcontext.Background()
+	}
+	return c.cancelCtx
+}
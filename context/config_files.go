@@ -0,0 +1,391 @@
+package context
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/data"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The config key that lists other config files (or dirs or globs) to load before the rest of the
+// file is rendered and merged, so its templates see their values in "prev". Relative paths are
+// relative to the including file.
+const IncludeKey = "include"
+
+var ConfigFileExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// Expands directories (to their immediate config files by name) and globs (to their config files,
+// sorted) into a deterministic list of files. Config files are those with ConfigFileExtensions.
+// Plain files are kept as is even if they have an unknown extension.
+func ExpandConfigPaths(paths []string) ([]string, error) {
+	ret := []string{}
+	for _, path := range paths {
+		if strings.ContainsAny(path, "*?[") {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid glob %v: %v", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No config files match %v", path)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && !info.IsDir() && isConfigFile(match) {
+					ret = append(ret, match)
+				}
+			}
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to find config path %v: %v", path, err)
+		}
+		if !info.IsDir() {
+			ret = append(ret, path)
+			continue
+		}
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read config dir %v: %v", path, err)
+		}
+		// ReadDir is already sorted by name
+		for _, info := range infos {
+			if !info.IsDir() && isConfigFile(info.Name()) {
+				ret = append(ret, filepath.Join(path, info.Name()))
+			}
+		}
+	}
+	return ret, nil
+}
+
+func isConfigFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, configExt := range ConfigFileExtensions {
+		if ext == configExt {
+			return true
+		}
+	}
+	return false
+}
+
+// The most times config is rendered when templates reference "self"
+const MaxConfigPasses = 10
+
+type configLoader struct {
+	ctx *Context
+	// Absolute paths of the files currently being loaded, outermost first
+	stack []string
+	// Absolute paths of the files already loaded, so ones included more than once are only merged once
+	loaded map[string]bool
+	// Whether any file references "self"
+	usesSelf bool
+	// Paths referenced directly under "self" by dotted path, checked once the config settles
+	selfRefs map[string][]string
+	// When set, files referencing "self" that fail are skipped since "self" isn't known yet
+	lenient bool
+	skipped int
+}
+
+// Loads the config and applies the overrides (again after any remote templates). If any file
+// references "self", the config is rendered again with the previous result as "self" until it no
+// longer changes. "self" starts empty and fields referenced under it that aren't known yet render
+// as a placeholder. Until the result settles, files referencing "self" are skipped if they fail to
+// render or unmarshal, and once it has they fail for real. Then every field referenced under
+// "self" must exist without a placeholder in it, so references that never resolve or only resolve
+// to each other are reported. Random values are the same every pass.
+func loadConfigFiles(ctx *Context, files []string, overrides []*Override) error {
+	// Even if loading fails, later templates shouldn't repeat values or see "self"
+	defer func() {
+		ctx.Data.SetRandomScope("")
+		ctx.Data.Self = nil
+	}()
+	ctx.Data.Self = map[string]interface{}{}
+	var passes []map[string]interface{}
+	lenient := true
+	for {
+		loader := &configLoader{ctx: ctx, lenient: lenient}
+		if err := loader.loadPaths(files); err != nil {
+			return err
+		}
+		ctx.dataBeforeOverrides = nil
+		if len(ctx.RemoteTemplates) > 0 {
+			ctx.dataBeforeOverrides = ctx.Data.Copy()
+		}
+		if err := applyOverrides(ctx.Data, overrides); err != nil {
+			return err
+		}
+		if !loader.usesSelf {
+			return nil
+		}
+		if len(passes) > 0 && reflect.DeepEqual(passes[len(passes)-1], ctx.Data.Values) {
+			if loader.skipped == 0 {
+				return checkSelfRefs(ctx.Data.Values, loader.selfRefs)
+			}
+			// Nothing else will resolve, so the next pass reports the errors
+			lenient = false
+		} else if len(passes) > 0 {
+			// Seeing an earlier pass again or never settling means the references are circular
+			circular := len(passes) >= MaxConfigPasses
+			for _, prevPass := range passes {
+				circular = circular || reflect.DeepEqual(prevPass, ctx.Data.Values)
+			}
+			if circular {
+				return fmt.Errorf("Config references to self do not settle, likely a cycle between: %v",
+					strings.Join(data.DiffPaths(passes[len(passes)-1], ctx.Data.Values), ", "))
+			}
+			lenient = loader.skipped > 0
+		}
+		passes = append(passes, ctx.Data.Values)
+		ctx.Debugf("Rendering config again to resolve references to self (pass %v)", len(passes)+1)
+		ctx.Data.Self = ctx.Data.Values
+		ctx.Data.Reset()
+		ctx.RemoteTemplates = nil
+	}
+}
+
+func (c *configLoader) loadPaths(paths []string) error {
+	files, err := ExpandConfigPaths(paths)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := c.loadFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *configLoader) loadFile(file string) error {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("Unable to make path of %v absolute: %v", file, err)
+	}
+	for i, loading := range c.stack {
+		if loading == absFile {
+			return fmt.Errorf("Include cycle: %v", strings.Join(append(c.stack[i:], absFile), " -> "))
+		}
+	}
+	if c.loaded[absFile] {
+		c.ctx.Debugf("Skipping config file %v since it was already loaded", file)
+		return nil
+	}
+	if c.loaded == nil {
+		c.loaded = map[string]bool{}
+	}
+	c.loaded[absFile] = true
+	c.stack = append(c.stack, absFile)
+	defer func() { c.stack = c.stack[:len(c.stack)-1] }()
+
+	byts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read file %v: %v", file, err)
+	}
+	// Invalid templates fail below anyway
+	usesSelf, _ := c.ctx.Data.TemplateReferences(byts, "self")
+	c.usesSelf = c.usesSelf || usesSelf
+	var selfPaths [][]string
+	if usesSelf {
+		selfPaths, _ = c.ctx.Data.TemplateFieldPaths(byts, "self")
+		if c.selfRefs == nil {
+			c.selfRefs = map[string][]string{}
+		}
+		for _, path := range selfPaths {
+			c.selfRefs[strings.Join(path, ".")] = path
+		}
+	}
+	if IsRemoteTemplateFile(file) {
+		rendered, err := c.render(absFile, byts, selfPaths)
+		if err != nil {
+			return c.skipOrFail(file, usesSelf, err)
+		}
+		// Caught early if it parses before remote templating, otherwise on the target host
+		values := map[string]interface{}{}
+		if UnmarshallerForFile(file)(rendered, &values) == nil {
+			if err := checkNoRemoteIncludes(file, values); err != nil {
+				return err
+			}
+		}
+		c.ctx.Debugf("Deferring remote config file %v", file)
+		c.ctx.RemoteTemplates = append(c.ctx.RemoteTemplates, &RemoteTemplate{
+			File:  file,
+			Text:  string(rendered),
+			Lines: sourceLines(byts, rendered, "{{"),
+		})
+		return nil
+	}
+	// Includes are loaded first so "prev" has their values and this file's values win
+	includes, err := c.includes(file, absFile, byts, selfPaths)
+	if err != nil {
+		return c.skipOrFail(file, usesSelf, err)
+	}
+	if len(includes) > 0 {
+		if err := c.loadPaths(includes); err != nil {
+			return fmt.Errorf("Unable to include from %v: %v", file, err)
+		}
+	}
+	rendered, err := c.render(absFile, byts, selfPaths)
+	if err != nil {
+		return c.skipOrFail(file, usesSelf, err)
+	}
+	newValues := map[string]interface{}{}
+	if err = UnmarshallerForFile(file)(rendered, &newValues); err != nil {
+		return c.skipOrFail(file, usesSelf, fmt.Errorf("Unable to unmarshal resulting text: %v", err))
+	}
+	delete(newValues, IncludeKey)
+	includedFrom := ""
+	if len(c.stack) > 1 {
+		includedFrom = c.stack[len(c.stack)-2]
+		c.ctx.Debugf("Loading config file %v (included from %v)", file, includedFrom)
+	} else {
+		c.ctx.Debugf("Loading config file %v", file)
+	}
+	lines := keyLinesForFile(file, rendered, sourceLines(byts, rendered, "{{"))
+	if err := c.ctx.Data.MergeFromIncluded(newValues, file, includedFrom, lines); err != nil {
+		return fmt.Errorf("Unable to merge config file %v: %v", file, err)
+	}
+	return nil
+}
+
+// We template and unmarshal ourselves instead of via data so we can find key lines
+func (c *configLoader) render(absFile string, byts []byte, selfPaths [][]string) ([]byte, error) {
+	c.ctx.Data.SetRandomScope(absFile)
+	if len(selfPaths) == 0 {
+		return c.ctx.Data.ApplyTemplate(byts)
+	}
+	self := c.ctx.Data.Self
+	defer func() { c.ctx.Data.Self = self }()
+	for _, path := range selfPaths {
+		c.ctx.Data.Self = withSelfPlaceholder(c.ctx.Data.Self, path)
+	}
+	return c.ctx.Data.ApplyTemplate(byts)
+}
+
+// Rendered for fields under "self" that aren't known yet
+const selfPlaceholder = "__syst_unresolved_self__"
+
+// A copy of self with the placeholder at the path if nothing is there. The path is left alone if
+// it goes through something that isn't a map, so rendering reports it.
+func withSelfPlaceholder(self map[string]interface{}, path []string) map[string]interface{} {
+	existing, ok := self[path[0]]
+	if ok && len(path) == 1 {
+		return self
+	}
+	var child map[string]interface{}
+	if ok {
+		if child, ok = existing.(map[string]interface{}); !ok {
+			return self
+		}
+	}
+	ret := make(map[string]interface{}, len(self)+1)
+	for key, value := range self {
+		ret[key] = value
+	}
+	if len(path) == 1 {
+		ret[path[0]] = selfPlaceholder
+	} else {
+		if child == nil {
+			child = map[string]interface{}{}
+		}
+		ret[path[0]] = withSelfPlaceholder(child, path[1:])
+	}
+	return ret
+}
+
+// Fails for referenced fields that are missing, or that still have a placeholder in them because
+// they are only ever resolved from each other
+func checkSelfRefs(values map[string]interface{}, refs map[string][]string) error {
+	var missing, unresolved []string
+	for ref, path := range refs {
+		if value, ok := valueAtPath(values, path); !ok {
+			missing = append(missing, ref)
+		} else if strings.Contains(fmt.Sprint(value), selfPlaceholder) {
+			unresolved = append(unresolved, ref)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unresolved)
+	if len(missing) > 0 {
+		return fmt.Errorf("Config references to self never resolve: %v", strings.Join(missing, ", "))
+	} else if len(unresolved) > 0 {
+		return fmt.Errorf("Config references to self do not resolve, likely a cycle between: %v",
+			strings.Join(unresolved, ", "))
+	}
+	return nil
+}
+
+func valueAtPath(values map[string]interface{}, path []string) (interface{}, bool) {
+	value, ok := values[path[0]]
+	if !ok || len(path) == 1 {
+		return value, ok
+	}
+	if child, isMap := value.(map[string]interface{}); isMap {
+		return valueAtPath(child, path[1:])
+	}
+	return nil, false
+}
+
+func (c *configLoader) skipOrFail(file string, usesSelf bool, err error) error {
+	if c.lenient && usesSelf {
+		c.ctx.Debugf("Skipping config file %v until self is known: %v", file, err)
+		c.skipped++
+		return nil
+	}
+	return fmt.Errorf("Error handling config file %v: %v", file, err)
+}
+
+var templateActionMatch = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+const templateActionPlaceholder = "__syst_template_action__"
+
+// Includes are taken from the file with its template actions replaced by placeholders if that
+// parses and they aren't templated themselves, otherwise from the file rendered without the
+// included values
+func (c *configLoader) includes(file string, absFile string, byts []byte,
+	selfPaths [][]string) ([]string, error) {
+	values := map[string]interface{}{}
+	placeheld := templateActionMatch.ReplaceAll(byts, []byte(templateActionPlaceholder))
+	if err := UnmarshallerForFile(file)(placeheld, &values); err != nil ||
+		strings.Contains(fmt.Sprint(values[IncludeKey]), templateActionPlaceholder) {
+		rendered, err := c.render(absFile, byts, selfPaths)
+		if err != nil {
+			return nil, err
+		}
+		values = map[string]interface{}{}
+		if err = UnmarshallerForFile(file)(rendered, &values); err != nil {
+			return nil, fmt.Errorf("Unable to unmarshal resulting text: %v", err)
+		}
+	}
+	return includePaths(values[IncludeKey], filepath.Dir(file))
+}
+
+func includePaths(v interface{}, relativeTo string) ([]string, error) {
+	var paths []string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		paths = []string{v}
+	case []interface{}:
+		for _, path := range v {
+			pathStr, ok := path.(string)
+			if !ok {
+				return nil, fmt.Errorf("Expected '%v' values to be strings, got %v", IncludeKey, path)
+			}
+			paths = append(paths, pathStr)
+		}
+	default:
+		return nil, fmt.Errorf("Expected '%v' to be a string or array of strings, got %v", IncludeKey, v)
+	}
+	for i, path := range paths {
+		if !filepath.IsAbs(path) {
+			paths[i] = filepath.Join(relativeTo, path)
+		}
+	}
+	return paths, nil
+}
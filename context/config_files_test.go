@@ -0,0 +1,155 @@
+package context
+
+import (
+	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/util"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFilesSelf(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		// Values checked after loading, by path
+		expected map[string]interface{}
+		// Substring of the expected error
+		wantErr string
+	}{
+		{"no self", []string{`{"a": "{{ .prev.b | default "x" }}"}`}, map[string]interface{}{"a": "x"}, ""},
+		{"later file", []string{`{"greeting": "hello {{ .self.name }}"}`, `{"name": "bob"}`},
+			map[string]interface{}{"greeting": "hello bob"}, ""},
+		{"chain", []string{`{"a": "{{ .self.b }}!"}`, `{"b": "{{ .self.c }}?"}`, `{"c": "c"}`},
+			map[string]interface{}{"a": "c?!", "b": "c?"}, ""},
+		{"same file", []string{"{\"host\": \"db\", \"url\": \"postgres://{{ index .self `host` | default `` }}/app\"}"},
+			map[string]interface{}{"url": "postgres://db/app"}, ""},
+		{"same file without default", []string{`{"app": {"port": 80}, "url": "http://x:{{ .self.app.port }}"}`},
+			map[string]interface{}{"url": "http://x:80"}, ""},
+		{"prev default alongside self", []string{`{"name": "bob"}`,
+			`{"a": "{{ .prev.missing | default "y" }}", "b": "{{ .self.name }} {{ .prev.name }}"}`},
+			map[string]interface{}{"a": "y", "b": "bob bob"}, ""},
+		{"direct cycle", []string{`{"a": "{{ .self.b }}", "b": "{{ .self.a }}"}`}, nil, "cycle between: a, b"},
+		{"cycle through other values", []string{`{"a": "{{ .self.b }}", "b": "{{ .self.a }}", "c": "{{ .self.a }}"}`},
+			nil, "cycle between: a, b"},
+		{"default until known", []string{"{\"a\": \"{{ index .self `b` | default `none` }}\", \"b\": 1}"},
+			map[string]interface{}{"a": "1"}, ""},
+		{"cycle with defaults", []string{"{\"a\": \"{{ index .self `b` | default `x` }}1\"," +
+			"\"b\": \"{{ index .self `a` | default `y` }}2\"}"}, nil, "do not settle"},
+		{"growing cycle", []string{"{\"a\": \"{{ index .self `a` | default `` }}x\"}"}, nil, "do not settle"},
+		{"never resolves", []string{`{"a": "{{ .self.nope }}"}`, `{"b": 1}`}, nil, "nope"},
+		{"randoms stable", []string{"{\"pass\": \"{{ randomPassword 12 }}\", \"copy\": \"{{ index .self `pass` | default `` }}\"}"},
+			nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "syst-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			files := []string{}
+			for i, contents := range test.files {
+				file := filepath.Join(dir, string('a'+rune(i))+".json")
+				if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, file)
+			}
+			ctx := newTestContext()
+			err = loadConfigFiles(ctx, files, nil)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			for path, expected := range test.expected {
+				if actual, err := ctx.Data.Get(path); err != nil || !reflect.DeepEqual(actual, expected) {
+					t.Fatalf("Expected %v at %v, got %v (%v)", expected, path, actual, err)
+				}
+			}
+			if pass, ok := ctx.Data.Values["pass"]; ok && pass != ctx.Data.Values["copy"] {
+				t.Fatalf("Random value changed between passes: %v then %v", ctx.Data.Values["copy"], pass)
+			}
+			if ctx.Data.Self != nil {
+				t.Fatal("Expected self to be cleared")
+			}
+		})
+	}
+}
+
+func TestLoadConfigFilesIncludes(t *testing.T) {
+	files := map[string]string{
+		"common.yaml": "hosts: [a]\n",
+		"x.yaml":      "include: common.yaml\nx: 1\n",
+		"y.yaml":      "include: [common.yaml]\ny: 1\n",
+		"main.json":   `{"include": ["x.yaml", "y.yaml"]}`,
+	}
+	tests := []struct {
+		name     string
+		paths    []string
+		expected []interface{}
+	}{
+		{"diamond", []string{"main.json"}, []interface{}{"a"}},
+		{"dir", []string{"."}, []interface{}{"a"}},
+		{"repeated path", []string{"common.yaml", "common.yaml"}, []interface{}{"a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "syst-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, contents := range files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			paths := []string{}
+			for _, path := range test.paths {
+				paths = append(paths, filepath.Join(dir, path))
+			}
+			ctx := newTestContext()
+			if err := loadConfigFiles(ctx, paths, nil); err != nil {
+				t.Fatal(err)
+			}
+			if actual := ctx.Data.Values["hosts"]; !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("Expected hosts %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFilesIncludeCycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syst-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range map[string]string{"a.yaml": "include: b.yaml\n", "b.yaml": "include: a.yaml\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = loadConfigFiles(newTestContext(), []string{filepath.Join(dir, "a.yaml")}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Include cycle") {
+		t.Fatalf("Expected include cycle, got %v", err)
+	}
+}
+
+func newTestContext() *Context {
+	redactor := util.NewRedactor()
+	return &Context{
+		Logger:   util.GoLoggerWrapper(log.New(ioutil.Discard, "", 0), false, redactor),
+		Redactor: redactor,
+		Data:     data.NewData(),
+	}
+}
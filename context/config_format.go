@@ -0,0 +1,315 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/util"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var unmarshalStripped = func(byts []byte, v interface{}) error {
+	// Strip comments (this happens after template application)
+	properByts, err := stripJSONComments(byts)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(properByts, v)
+}
+
+func stripJSONComments(byts []byte) ([]byte, error) {
+	properByts, err := ioutil.ReadAll(util.NewCommentStrippedJSONReader(bytes.NewBuffer(byts)))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to strip JSON comments: %v", err)
+	}
+	return properByts, nil
+}
+
+var unmarshalYAML = func(byts []byte, v interface{}) error {
+	var raw interface{}
+	if err := yaml.Unmarshal(byts, &raw); err != nil {
+		return err
+	}
+	return unmarshalAsJSON(raw, v)
+}
+
+var unmarshalTOML = func(byts []byte, v interface{}) error {
+	raw := map[string]interface{}{}
+	if err := toml.Unmarshal(byts, &raw); err != nil {
+		return err
+	}
+	return unmarshalAsJSON(raw, v)
+}
+
+// Obtains the unmarshaller for the config file by extension. Anything not YAML or TOML is
+// treated as JSON with comments.
+func UnmarshallerForFile(file string) data.UnmarshalFunc {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return unmarshalYAML
+	case ".toml":
+		return unmarshalTOML
+	default:
+		return unmarshalStripped
+	}
+}
+
+// Obtains the line of each object key by dotted path, ignoring anything inside arrays. This is
+// best effort, so nil is returned for unsupported formats or unparseable text. The text is
+// rendered, so the lines are mapped back to the source via lineSources (see sourceLines), leaving
+// out keys whose line isn't known. A nil lineSources means the lines are the same.
+func keyLinesForFile(file string, byts []byte, lineSources map[int]int) map[string]int {
+	lines := renderedKeyLines(file, byts)
+	if lineSources == nil {
+		return lines
+	}
+	for key, line := range lines {
+		if sourceLine, ok := lineSources[line]; ok {
+			lines[key] = sourceLine
+		} else {
+			delete(lines, key)
+		}
+	}
+	return lines
+}
+
+func renderedKeyLines(file string, byts []byte) map[string]int {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		node := &yaml.Node{}
+		if err := yaml.Unmarshal(byts, node); err != nil || len(node.Content) == 0 {
+			return nil
+		}
+		lines := map[string]int{}
+		yamlKeyLines(node.Content[0], "", lines)
+		return lines
+	case ".toml":
+		return nil
+	default:
+		// Comments on their own lines don't change line numbers, only multiline block comments do
+		stripped, err := stripJSONComments(byts)
+		if err != nil {
+			return nil
+		}
+		return jsonKeyLines(stripped)
+	}
+}
+
+// Maps each line of the rendered text to the line of the template it came from, best effort, or
+// nil if rendering changed nothing. Lines are matched in order, either exactly or, for template
+// lines with actions, by the text before the first action (e.g. "port: " of "port: {{ .prev.x }}").
+// Lines only produced by actions aren't mapped.
+func sourceLines(source []byte, rendered []byte, leftDelim string) map[int]int {
+	if bytes.Equal(source, rendered) {
+		return nil
+	}
+	srcLines := strings.Split(string(source), "\n")
+	ret := map[int]int{}
+	next := 0
+	for i, line := range strings.Split(string(rendered), "\n") {
+		// Blank lines would match too eagerly
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for j := next; j < len(srcLines); j++ {
+			if renderedLineMatches(srcLines[j], line, leftDelim) {
+				ret[i+1] = j + 1
+				next = j + 1
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func renderedLineMatches(source string, rendered string, leftDelim string) bool {
+	index := strings.Index(source, leftDelim)
+	if index == -1 {
+		return source == rendered
+	}
+	prefix := source[:index]
+	return strings.TrimSpace(prefix) != "" && strings.HasPrefix(rendered, prefix)
+}
+
+func yamlKeyLines(node *yaml.Node, path string, lines map[string]int) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, _ := data.SplitMergeKey(node.Content[i].Value)
+		childPath := data.JoinPath(path, key)
+		lines[childPath] = node.Content[i].Line
+		yamlKeyLines(node.Content[i+1], childPath, lines)
+	}
+}
+
+func jsonKeyLines(byts []byte) map[string]int {
+	type frame struct {
+		path      string
+		object    bool
+		inArray   bool
+		expectKey bool
+		key       string
+	}
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(byts))
+	stack := []*frame{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return lines
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.expectKey {
+			if key, ok := tok.(string); ok {
+				key, _ = data.SplitMergeKey(key)
+				top.key = data.JoinPath(top.path, key)
+				top.expectKey = false
+				if !top.inArray {
+					lines[top.key] = 1 + bytes.Count(byts[:dec.InputOffset()], []byte("\n"))
+				}
+				continue
+			}
+		}
+		childPath, inArray := "", false
+		if top != nil {
+			childPath, inArray = top.key, top.inArray || !top.object
+		}
+		switch tok {
+		case json.Delim('{'):
+			stack = append(stack, &frame{path: childPath, object: true, inArray: inArray, expectKey: true})
+		case json.Delim('['):
+			stack = append(stack, &frame{path: childPath, inArray: inArray})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].expectKey = true
+			}
+		default:
+			if top != nil {
+				top.expectKey = true
+			}
+		}
+	}
+}
+
+// We send everything through JSON so the values have the same types regardless of format (e.g.
+// float64 numbers and map[string]interface{} objects) which is what the merge expects.
+func unmarshalAsJSON(raw interface{}, v interface{}) error {
+	jsonable, err := jsonCompatible(raw)
+	if err != nil {
+		return err
+	}
+	byts, err := json.Marshal(jsonable)
+	if err != nil {
+		return fmt.Errorf("Unable to convert to JSON: %v", err)
+	}
+	return json.Unmarshal(byts, v)
+}
+
+func jsonCompatible(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			keyStr, ok := key.(string)
+			if !ok {
+				keyStr = fmt.Sprintf("%v", key)
+			}
+			newVal, err := jsonCompatible(val)
+			if err != nil {
+				return nil, err
+			}
+			ret[keyStr] = newVal
+		}
+		return ret, nil
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			newVal, err := jsonCompatible(val)
+			if err != nil {
+				return nil, err
+			}
+			ret[key] = newVal
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, val := range v {
+			newVal, err := jsonCompatible(val)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = newVal
+		}
+		return ret, nil
+	case []map[string]interface{}:
+		ret := make([]interface{}, len(v))
+		for i, val := range v {
+			newVal, err := jsonCompatible(val)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = newVal
+		}
+		return ret, nil
+	default:
+		return v, nil
+	}
+}
+
+// Loads a schema from a JSON (with comments), YAML, or TOML file. Schema files are not templated.
+func SchemaFromFile(file string) (*data.Schema, error) {
+	byts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read schema file %v: %v", file, err)
+	}
+	return SchemaFromBytes(file, byts)
+}
+
+// Same as SchemaFromFile for contents already read (e.g. via resources). The file name decides
+// the format.
+func SchemaFromBytes(file string, byts []byte) (*data.Schema, error) {
+	m := map[string]interface{}{}
+	if err := UnmarshallerForFile(file)(byts, &m); err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal schema file %v: %v", file, err)
+	}
+	schema, err := data.SchemaFromMap(m)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schema file %v: %v", file, err)
+	}
+	return schema, nil
+}
+
+// Loads prompt answers from a JSON (with comments), YAML, or TOML file of keys to answers. Answers
+// files are not templated.
+func AnswersFromFile(file string) (map[string]string, error) {
+	byts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read answers file %v: %v", file, err)
+	}
+	m := map[string]interface{}{}
+	if err = UnmarshallerForFile(file)(byts, &m); err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal answers file %v: %v", file, err)
+	}
+	answers := map[string]string{}
+	for key, val := range m {
+		switch val := val.(type) {
+		case string:
+			answers[key] = val
+		case float64, bool:
+			answers[key] = fmt.Sprintf("%v", val)
+		default:
+			return nil, fmt.Errorf("Answer for %v in %v must be a string, number, or boolean", key, file)
+		}
+	}
+	return answers, nil
+}
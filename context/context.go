@@ -1,48 +1,68 @@
 package context
 
 import (
-	"bytes"
+	stdcontext "context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/facts"
 	"github.com/cretz/systrument/resource"
 	"github.com/cretz/systrument/util"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"text/template"
 )
 
 type Context struct {
 	util.Logger
+	util.Redactor
 	resource.Resources
 	Data         *data.Data
 	IsRemote     bool
 	BaseLocalDir string
 	TempDir      string
 	RemotePipe   *LocalToRemotePipe
+	// Show command output at info level instead of debug level
+	StreamOutput bool
+	// Config files to be evaluated on the target host
+	RemoteTemplates []*RemoteTemplate
+	// Applied after all config files, so again after remote templates
+	Overrides []*Override
+	// While there are remote templates, the data they are merged into
+	dataBeforeOverrides *data.Data
+	cancelCtx           stdcontext.Context
+	cancel              stdcontext.CancelFunc
 }
 
-var unmarshalStripped = func(byts []byte, v interface{}) error {
-	// Strip comments (this happens after template application)
-	properByts, err := ioutil.ReadAll(util.NewCommentStrippedJSONReader(bytes.NewBuffer(byts)))
-	if err != nil {
-		return fmt.Errorf("Unable to strip JSON comments: %v", err)
-	}
-	return json.Unmarshal(properByts, v)
-}
-
-func FromConfigFiles(files []string, verbose bool, overrideLocalDir string) (*Context, error) {
+// The funcs are registered as template functions before any config is loaded. The prompter may be
+// nil to use one without answers. The overrides are applied in order after all files.
+func FromConfigFiles(files []string, verbose bool, overrideLocalDir string, prompter *data.Prompter,
+	overrides []*Override, funcs ...template.FuncMap) (*Context, error) {
 	tempDir, err := ioutil.TempDir(os.TempDir(), "syst-temp")
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create temporary dir: %v", err)
 	}
+	redactor := util.NewRedactor()
 	ctx := &Context{
-		Logger:       util.GoLoggerWrapper(log.New(os.Stdout, "", log.LstdFlags), verbose),
+		Logger:       util.GoLoggerWrapper(log.New(os.Stdout, "", log.LstdFlags), verbose, redactor),
+		Redactor:     redactor,
 		Resources:    resource.LocalResources(),
 		Data:         data.NewData(),
 		TempDir:      tempDir,
 		BaseLocalDir: overrideLocalDir,
+		Overrides:    overrides,
 	}
+	ctx.initCancel()
+	ctx.Data.Resources = ctx.Resources
+	ctx.Data.RegisterFuncs(append([]template.FuncMap{facts.Funcs(ctx.Data)}, funcs...)...)
+	if prompter == nil {
+		prompter = data.NewPrompter(nil)
+	}
+	prompter.OnHiddenAnswer = func(answer string) { ctx.AddSecrets(answer) }
+	ctx.Data.Prompter = prompter
 	if overrideLocalDir == "" {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -50,20 +70,17 @@ func FromConfigFiles(files []string, verbose bool, overrideLocalDir string) (*Co
 		}
 		ctx.BaseLocalDir = wd
 	}
-	// Load each file, strip JSON comments, load into data
-	for _, file := range files {
-		byts, err := ioutil.ReadFile(file)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to read file %v: %v", file, err)
-		}
-		if err = ctx.Data.ApplyTemplateAndMerge(byts, unmarshalStripped); err != nil {
-			return nil, fmt.Errorf("Error handling config file %v: %v", file, err)
-		}
+	// Load each file (expanding dirs, globs, and includes), unmarshal based on extension, load into data
+	if err := loadConfigFiles(ctx, files, overrides); err != nil {
+		// Errors can echo config values, so mask the secrets loaded so far
+		ctx.AddSecrets(ctx.Data.SecretValues()...)
+		return nil, errors.New(ctx.Redact(err.Error()))
 	}
+	ctx.AddSecrets(ctx.Data.SecretValues()...)
 	return ctx, nil
 }
 
-func FromRemoteStdPipe(verbose bool, overrideLocalDir string) (*Context, error) {
+func FromRemoteStdPipe(verbose bool, overrideLocalDir string, funcs ...template.FuncMap) (*Context, error) {
 	tempDir, err := ioutil.TempDir(os.TempDir(), "syst-temp")
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create temporary dir: %v", err)
@@ -73,23 +90,79 @@ func FromRemoteStdPipe(verbose bool, overrideLocalDir string) (*Context, error)
 		return nil, fmt.Errorf("Unable to change temp dir privs: %v", err)
 	}
 	pipe := NewLocalToRemotePipe(os.Stdin, os.Stdout)
-	// We need to grab the data from the remote
-	// TODO: I would like to support << and >> for remote template parsing, but it would require
-	// 	us sending back the entire set of data each time because we need "prev" data
+	// We need to grab the data and the templates to evaluate here from the remote
 	conf, err := pipe.Request("get-context-data")
 	if err != nil {
 		return nil, fmt.Errorf("Unable to get context data: %v", err)
 	}
+	templates, err := pipe.Request("get-remote-templates")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get remote templates: %v", err)
+	}
+	overrides, err := pipe.Request("get-overrides")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get overrides: %v", err)
+	}
 	ctx := &Context{}
-	ctx.Logger = util.GoLoggerWrapper(log.New(os.Stdout, "", log.LstdFlags), verbose)
+	ctx.initCancel()
+	ctx.Redactor = util.NewRedactor()
+	ctx.Logger = util.GoLoggerWrapper(log.New(os.Stdout, "", log.LstdFlags), verbose, ctx.Redactor)
 	ctx.Resources = newRemoteResources(ctx)
 	ctx.Data = data.NewData()
+	ctx.Data.Resources = ctx.Resources
+	ctx.Data.RegisterFuncs(append([]template.FuncMap{facts.Funcs(ctx.Data)}, funcs...)...)
+	// Prompts can't use our stdin since it's the pipe, so the controller answers them
+	ctx.Data.Prompter = &data.Prompter{
+		Relay: func(req *data.PromptRequest) (string, error) {
+			byts, err := json.Marshal(req)
+			if err != nil {
+				return "", fmt.Errorf("Unable to marshal prompt: %v", err)
+			}
+			return pipe.Request("prompt " + string(byts))
+		},
+		OnHiddenAnswer: func(answer string) { ctx.AddSecrets(answer) },
+	}
 	if err = json.Unmarshal([]byte(conf), &ctx.Data.Values); err != nil {
 		return nil, fmt.Errorf("Unable to unmarshal context data from JSON: %v", err)
 	}
+	if err = json.Unmarshal([]byte(templates), &ctx.RemoteTemplates); err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal remote templates from JSON: %v", err)
+	}
+	if err = json.Unmarshal([]byte(overrides), &ctx.Overrides); err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal overrides from JSON: %v", err)
+	}
+	ctx.AddSecrets(ctx.Data.SecretValues()...)
 	ctx.IsRemote = true
 	ctx.BaseLocalDir = overrideLocalDir
 	ctx.TempDir = tempDir
 	ctx.RemotePipe = pipe
+	if err = ctx.ApplyRemoteTemplates(); err != nil {
+		return nil, err
+	}
 	return ctx, nil
 }
+
+// The values to send to the target host. With remote templates, these are without the overrides
+// since they are applied after the remote templates there.
+func (c *Context) RemoteData() map[string]interface{} {
+	if len(c.RemoteTemplates) > 0 && c.dataBeforeOverrides != nil {
+		return c.dataBeforeOverrides.Values
+	}
+	return c.Data.Values
+}
+
+// Registers custom template functions for config and resource templates. To be seen by config
+// templates, functions must instead be given when the context is created.
+func (c *Context) RegisterTemplateFuncs(funcs ...template.FuncMap) {
+	c.Data.RegisterFuncs(funcs...)
+}
+
+// Reads the template at the local path via resources and applies it with the given value as the
+// dot using the built-in and registered template functions
+func (c *Context) ApplyTemplateFile(localPath string, v interface{}) ([]byte, error) {
+	byts, err := c.ReadFile(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read template %v: %v", localPath, err)
+	}
+	return c.Data.ExecuteTemplate(filepath.Base(localPath), byts, v)
+}
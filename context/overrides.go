@@ -0,0 +1,143 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cretz/systrument/data"
+	"sort"
+	"strings"
+)
+
+// Env vars with this prefix override config values, with "__" separating keys (e.g.
+// SYST_APP__PORT=80 sets app.port). Keys match existing keys ignoring case and underscores, and
+// are lower case otherwise. Prompt answer env vars are not overrides.
+const (
+	EnvOverridePrefix    = "SYST_"
+	EnvOverrideSeparator = "__"
+)
+
+// A single value set on top of all config files (including remote ones) using the same merge rules
+// as config files
+type Override struct {
+	// Dotted, but without indexes. Keys can have merge strategy suffixes.
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	// Where it came from, for provenance
+	Source string `json:"source"`
+	// Match existing keys ignoring case and underscores
+	FuzzyKeys bool `json:"fuzzyKeys"`
+}
+
+// Parses "key.path=value". Unless asJSON, values that are JSON numbers, booleans, or null are
+// used as such and anything else is a string.
+func ParseOverride(str string, asJSON bool) (*Override, error) {
+	pieces := strings.SplitN(str, "=", 2)
+	if len(pieces) != 2 || pieces[0] == "" {
+		return nil, fmt.Errorf("Expected key.path=value, got %v", str)
+	}
+	o := &Override{Path: pieces[0], Source: "--set"}
+	if asJSON {
+		o.Source = "--set-json"
+		if err := json.Unmarshal([]byte(pieces[1]), &o.Value); err != nil {
+			return nil, fmt.Errorf("Invalid JSON for %v: %v", pieces[0], err)
+		}
+	} else {
+		o.Value = scalarValue(pieces[1])
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Obtains overrides from env vars in "key=value" form (e.g. os.Environ()), sorted by name
+func EnvOverrides(environ []string) ([]*Override, error) {
+	ret := []*Override{}
+	for _, env := range environ {
+		pieces := strings.SplitN(env, "=", 2)
+		if len(pieces) != 2 || !strings.HasPrefix(pieces[0], EnvOverridePrefix) ||
+			strings.HasPrefix(pieces[0], data.PromptEnvPrefix) {
+			continue
+		}
+		keys := strings.Split(strings.ToLower(strings.TrimPrefix(pieces[0], EnvOverridePrefix)), EnvOverrideSeparator)
+		o := &Override{
+			Path:      strings.Join(keys, "."),
+			Value:     scalarValue(pieces[1]),
+			Source:    "env " + pieces[0],
+			FuzzyKeys: true,
+		}
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid env var %v: %v", pieces[0], err)
+		}
+		ret = append(ret, o)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Source < ret[j].Source })
+	return ret, nil
+}
+
+func scalarValue(str string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(str), &v); err == nil {
+		switch v.(type) {
+		case float64, bool, nil:
+			return v
+		}
+	}
+	return str
+}
+
+func (o *Override) validate() error {
+	if strings.Contains(o.Path, "[") {
+		return fmt.Errorf("Indexes not supported in override path %v", o.Path)
+	}
+	for _, key := range strings.Split(o.Path, ".") {
+		if key == "" {
+			return fmt.Errorf("Empty key in override path %v", o.Path)
+		}
+	}
+	return nil
+}
+
+func (o *Override) apply(d *data.Data) error {
+	keys := strings.Split(o.Path, ".")
+	if o.FuzzyKeys {
+		keys = matchKeys(d.Values, keys)
+	}
+	// Build it up from the leaf so it merges like it came from a file
+	newValues := map[string]interface{}{keys[len(keys)-1]: o.Value}
+	for i := len(keys) - 2; i >= 0; i-- {
+		newValues = map[string]interface{}{keys[i]: newValues}
+	}
+	return d.MergeFrom(newValues, o.Source, nil)
+}
+
+func matchKeys(values map[string]interface{}, keys []string) []string {
+	ret := make([]string, len(keys))
+	curr := values
+	for i, key := range keys {
+		ret[i] = key
+		var next map[string]interface{}
+		for existing, v := range curr {
+			if normalizeKey(existing) == normalizeKey(key) {
+				ret[i] = existing
+				next, _ = v.(map[string]interface{})
+				break
+			}
+		}
+		curr = next
+	}
+	return ret
+}
+
+func normalizeKey(key string) string {
+	return strings.Replace(strings.ToLower(key), "_", "", -1)
+}
+
+func applyOverrides(d *data.Data, overrides []*Override) error {
+	for _, o := range overrides {
+		if err := o.apply(d); err != nil {
+			return fmt.Errorf("Unable to apply override from %v: %v", o.Source, err)
+		}
+	}
+	return nil
+}
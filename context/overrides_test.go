@@ -0,0 +1,104 @@
+package context
+
+import (
+	"encoding/json"
+	"github.com/cretz/systrument/data"
+	"reflect"
+	"testing"
+)
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		str     string
+		asJSON  bool
+		path    string
+		value   interface{}
+		source  string
+		wantErr bool
+	}{
+		{"a.b=x", false, "a.b", "x", "--set", false},
+		{"a=1", false, "a", 1.0, "--set", false},
+		{"a=true", false, "a", true, "--set", false},
+		{"a=null", false, "a", nil, "--set", false},
+		{`a=[1]`, false, "a", "[1]", "--set", false},
+		{"a=x=y", false, "a", "x=y", "--set", false},
+		{"a=", false, "a", "", "--set", false},
+		{"a$append=x", false, "a$append", "x", "--set", false},
+		{`a=[1, "x"]`, true, "a", []interface{}{1.0, "x"}, "--set-json", false},
+		{`a={"b": 1}`, true, "a", map[string]interface{}{"b": 1.0}, "--set-json", false},
+		{"a=x", true, "", nil, "", true},
+		{"a", false, "", nil, "", true},
+		{"=x", false, "", nil, "", true},
+		{"a..b=x", false, "", nil, "", true},
+		{"a[0]=x", false, "", nil, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.str, func(t *testing.T) {
+			o, err := ParseOverride(test.str, test.asJSON)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if err == nil && (o.Path != test.path || !reflect.DeepEqual(o.Value, test.value) || o.Source != test.source) {
+				t.Fatalf("Expected %v=%#v from %v, got %v=%#v from %v", test.path, test.value, test.source,
+					o.Path, o.Value, o.Source)
+			}
+		})
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	overrides, err := EnvOverrides([]string{"SYST_B=2", "HOME=/root", "SYST_ANSWER_DB_PASSWORD=x", "SYST_APP__PORT=80"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Override{
+		{Path: "app.port", Value: 80.0, Source: "env SYST_APP__PORT", FuzzyKeys: true},
+		{Path: "b", Value: 2.0, Source: "env SYST_B", FuzzyKeys: true},
+	}
+	if !reflect.DeepEqual(overrides, expected) {
+		t.Fatalf("Expected %v, got %v", expected, overrides)
+	}
+	if _, err := EnvOverrides([]string{"SYST_A____B=1"}); err == nil {
+		t.Fatal("Expected error for empty key")
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []*Override
+		expected  string
+	}{
+		{"set", []*Override{{Path: "a.b", Value: 2.0}}, `{"a": {"b": 2, "c": [1]}, "myApp": {"http_port": 1}}`},
+		{"new key", []*Override{{Path: "x.y", Value: "z"}},
+			`{"a": {"b": 1, "c": [1]}, "myApp": {"http_port": 1}, "x": {"y": "z"}}`},
+		{"merges arrays", []*Override{{Path: "a.c", Value: []interface{}{2.0}}},
+			`{"a": {"b": 1, "c": [1, 2]}, "myApp": {"http_port": 1}}`},
+		{"merge suffix", []*Override{{Path: "a.c$replace", Value: []interface{}{2.0}}},
+			`{"a": {"b": 1, "c": [2]}, "myApp": {"http_port": 1}}`},
+		{"fuzzy keys", []*Override{{Path: "myapp.httpport", Value: 2.0, FuzzyKeys: true}},
+			`{"a": {"b": 1, "c": [1]}, "myApp": {"http_port": 2}}`},
+		{"exact keys", []*Override{{Path: "myapp.httpport", Value: 2.0}},
+			`{"a": {"b": 1, "c": [1]}, "myApp": {"http_port": 1}, "myapp": {"httpport": 2}}`},
+		{"in order", []*Override{{Path: "a.b", Value: 2.0}, {Path: "a.b", Value: 3.0}},
+			`{"a": {"b": 3, "c": [1]}, "myApp": {"http_port": 1}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := data.NewData()
+			if err := json.Unmarshal([]byte(`{"a": {"b": 1, "c": [1]}, "myApp": {"http_port": 1}}`), &d.Values); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyOverrides(d, test.overrides); err != nil {
+				t.Fatal(err)
+			}
+			expected := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d.Values, expected) {
+				t.Fatalf("Expected %v, got %v", expected, d.Values)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package context
+
+import (
+	"github.com/cretz/systrument/util"
+)
+
+// Creates the redactor if the context was built without one
+func (c *Context) AddSecrets(secrets ...string) {
+	if c.Redactor == nil {
+		c.Redactor = util.NewRedactor()
+	}
+	c.Redactor.AddSecrets(secrets...)
+}
+
+func (c *Context) Redact(str string) string {
+	if c.Redactor == nil {
+		return str
+	}
+	return c.Redactor.Redact(str)
+}
+
+func (c *Context) RedactPartial(str string) (string, string) {
+	if c.Redactor == nil {
+		return str, ""
+	}
+	return c.Redactor.RedactPartial(str)
+}
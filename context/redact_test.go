@@ -0,0 +1,39 @@
+package context
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextWithoutRedactor(t *testing.T) {
+	ctx := &Context{}
+	if actual := ctx.Redact("pass hunter22"); actual != "pass hunter22" {
+		t.Fatalf("Expected nothing redacted, got %q", actual)
+	}
+	ctx.AddSecrets("hunter22")
+	if actual := ctx.Redact("pass hunter22"); actual != "pass ******" {
+		t.Fatalf("Expected secret redacted, got %q", actual)
+	}
+}
+
+func TestFromConfigFilesErrorRedacted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syst-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	if err := ioutil.WriteFile(files[0], []byte(`{"password": "hunter22"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(files[1], []byte(`{"x": "{{ cidrHost 1 .prev.password }}"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = FromConfigFiles(files, false, dir, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "CIDR") || strings.Contains(err.Error(), "hunter22") {
+		t.Fatalf("Expected redacted CIDR error, got %v", err)
+	}
+}
@@ -12,7 +12,10 @@ import (
 
 // I acknowledge this std pipe communication is synchronous and naive (for now)
 
-var requestMatcher = regexp.MustCompile("\\[syst-request-(\\d*)\\](.*)\\n")
+// Requests carry their length so one mangled by other output on the same stream (e.g. stderr when
+// the remote command is in a terminal) is rejected instead of handled. They can start mid-line for
+// the same reason.
+var requestMatcher = regexp.MustCompile("\\[syst-request-(\\d+):(\\d+)\\](.*)")
 
 type LocalToRemotePipe struct {
 	stdin   io.Reader
@@ -34,7 +37,8 @@ func (l *LocalToRemotePipe) Request(request string) (string, error) {
 	}
 	l.counter++
 	id := strconv.Itoa(l.counter)
-	if _, err := l.stdout.Write([]byte("[syst-request-" + id + "]" + request + "\n")); err != nil {
+	header := "[syst-request-" + id + ":" + strconv.Itoa(len(request)) + "]"
+	if _, err := l.stdout.Write([]byte(header + request + "\n")); err != nil {
 		return "", fmt.Errorf("Unable to write request to stdout: %v", err)
 	}
 	// TODO: timeout please
@@ -69,6 +73,8 @@ func (l *LocalToRemotePipe) Request(request string) (string, error) {
 type RemoteToLocalPipeListener struct {
 	remoteStdin io.Writer
 	handler     func(request string) (string, error)
+	// Requests can be split across writes, so this holds the incomplete last line
+	buf []byte
 }
 
 func NewRemoteToLocalPipeListener(remoteStdin io.Writer, handler func(request string) (string, error)) *RemoteToLocalPipeListener {
@@ -79,13 +85,43 @@ func NewRemoteToLocalPipeListener(remoteStdin io.Writer, handler func(request st
 }
 
 func (r *RemoteToLocalPipeListener) Write(p []byte) (int, error) {
-	matches := requestMatcher.FindSubmatch(p)
-	if matches == nil || len(matches) != 3 {
-		return len(p), nil
+	r.buf = append(r.buf, p...)
+	for {
+		index := bytes.IndexByte(r.buf, '\n')
+		if index == -1 {
+			break
+		}
+		line := r.buf[:index]
+		r.buf = r.buf[index+1:]
+		if err := r.handleLine(line); err != nil {
+			return len(p), err
+		}
+	}
+	// Only what could still become a request is worth holding on to
+	if !bytes.Contains(r.buf, []byte("[syst-request-")) {
+		if index := bytes.LastIndexByte(r.buf, '['); index >= 0 {
+			r.buf = append(r.buf[:0], r.buf[index:]...)
+		} else {
+			r.buf = r.buf[:0]
+		}
+	}
+	return len(p), nil
+}
+
+func (r *RemoteToLocalPipeListener) handleLine(line []byte) error {
+	matches := requestMatcher.FindSubmatch(line)
+	if matches == nil || len(matches) != 4 {
+		return nil
 	}
 	id := string(matches[1])
 	resp := "[syst-response-begin-" + id + "]\n"
-	str, err := r.handler(string(matches[2]))
+	var str string
+	var err error
+	if length, _ := strconv.Atoi(string(matches[2])); length != len(matches[3]) {
+		err = fmt.Errorf("Request was %v bytes instead of %v, other output may have been mixed in", len(matches[3]), length)
+	} else {
+		str, err = r.handler(string(matches[3]))
+	}
 	if err != nil {
 		resp += "ERROR: " + err.Error() + "\n"
 	} else {
@@ -93,7 +129,7 @@ func (r *RemoteToLocalPipeListener) Write(p []byte) (int, error) {
 	}
 	resp += "[syst-response-end-" + id + "]\n"
 	if _, err := r.remoteStdin.Write([]byte(resp)); err != nil && err != io.EOF {
-		return len(p), fmt.Errorf("Failure writing remote response: %v", err)
+		return fmt.Errorf("Failure writing remote response: %v", err)
 	}
-	return len(p), nil
+	return nil
 }
@@ -0,0 +1,103 @@
+package context
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/facts"
+	"path/filepath"
+	"strings"
+)
+
+// Config files with ".remote" before the extension (e.g. "app.remote.yaml") are evaluated on the
+// target host instead of locally. They are first templated locally like any other config file,
+// then templated with these delimiters on the target host with "prev" as all of the data and
+// "remote" as the host's facts. They are merged after all local config files regardless of where
+// they were loaded, but before the overrides. Includes are not supported in remote config files and
+// are an error.
+const (
+	RemoteTemplateLeftDelim  = "<<"
+	RemoteTemplateRightDelim = ">>"
+)
+
+type RemoteTemplate struct {
+	File string `json:"file"`
+	// After local template application
+	Text string `json:"text"`
+	// The line in File of each line in Text, see sourceLines
+	Lines map[int]int `json:"lines"`
+}
+
+func IsRemoteTemplateFile(file string) bool {
+	base := filepath.Base(file)
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base))), ".remote")
+}
+
+// Applies and merges the remote templates in order on this host, then the overrides, and removes
+// them. This is done automatically on the remote side but must be called explicitly when running
+// locally.
+func (c *Context) ApplyRemoteTemplates() error {
+	if len(c.RemoteTemplates) == 0 {
+		return nil
+	}
+	// Locally the overrides were already applied, so start over from before them. Remotely the data
+	// is already from before them.
+	if c.dataBeforeOverrides != nil {
+		c.Data.Values, c.Data.Sources = c.dataBeforeOverrides.Values, c.dataBeforeOverrides.Sources
+		c.dataBeforeOverrides = nil
+	}
+	hostFacts, err := facts.Get(c.Data)
+	if err != nil {
+		return fmt.Errorf("Unable to gather host facts: %v", err)
+	}
+	hostValues, err := hostFacts.Map()
+	if err != nil {
+		return err
+	}
+	for _, tmpl := range c.RemoteTemplates {
+		byts, err := c.Data.ApplyTemplateWithValues([]byte(tmpl.Text), RemoteTemplateLeftDelim,
+			RemoteTemplateRightDelim, map[string]interface{}{"remote": hostValues})
+		if err != nil {
+			return fmt.Errorf("Error handling remote config file %v: %v", tmpl.File, err)
+		}
+		newValues := map[string]interface{}{}
+		if err = UnmarshallerForFile(tmpl.File)(byts, &newValues); err != nil {
+			return fmt.Errorf("Error handling remote config file %v: Unable to unmarshal resulting text: %v", tmpl.File, err)
+		} else if err = checkNoRemoteIncludes(tmpl.File, newValues); err != nil {
+			return err
+		}
+		if err = c.Data.MergeFrom(newValues, tmpl.File, keyLinesForFile(tmpl.File, byts, tmpl.sourceLines(byts))); err != nil {
+			return fmt.Errorf("Unable to merge remote config file %v: %v", tmpl.File, err)
+		}
+	}
+	if err = applyOverrides(c.Data, c.Overrides); err != nil {
+		return err
+	}
+	c.RemoteTemplates = nil
+	c.AddSecrets(c.Data.SecretValues()...)
+	return nil
+}
+
+// The included files would have to be sent along and loaded on the target host
+func checkNoRemoteIncludes(file string, values map[string]interface{}) error {
+	if _, ok := values[IncludeKey]; ok {
+		return fmt.Errorf("Error handling remote config file %v: '%v' is not supported in remote config files",
+			file, IncludeKey)
+	}
+	return nil
+}
+
+// Maps lines of the text rendered on this host back through the local rendering to File
+func (r *RemoteTemplate) sourceLines(rendered []byte) map[int]int {
+	remoteLines := sourceLines([]byte(r.Text), rendered, RemoteTemplateLeftDelim)
+	if remoteLines == nil {
+		return r.Lines
+	} else if r.Lines == nil {
+		return remoteLines
+	}
+	ret := map[int]int{}
+	for renderedLine, textLine := range remoteLines {
+		if line, ok := r.Lines[textLine]; ok {
+			ret[renderedLine] = line
+		}
+	}
+	return ret
+}
@@ -0,0 +1,60 @@
+package context
+
+import (
+	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/util"
+	"time"
+)
+
+// The config key for retry settings. Settings directly under it apply to every operation and
+// settings under a sub key named for the operation (e.g. "ssh", "transfer", "git") override those.
+// Delays are seconds or strings like "1m30s".
+const RetryKey = "retry"
+
+// Builds the retry policy for the named operation from util.DefaultRetryPolicy and config. It is
+// cancelled with the context and logs each retry.
+func (c *Context) RetryPolicy(name string) (*util.RetryPolicy, error) {
+	policy := util.DefaultRetryPolicy
+	for _, path := range []string{RetryKey, RetryKey + "." + name} {
+		if err := retrySettings(c.Data, path, &policy); err != nil {
+			return nil, err
+		}
+	}
+	policy.Cancel = c.Done()
+	policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+		c.Infof("Attempt %v of %v (%v) failed, retrying in %v: %v", attempt, policy.Attempts, name, delay, err)
+	}
+	return &policy, nil
+}
+
+func retrySettings(d *data.Data, path string, policy *util.RetryPolicy) (err error) {
+	if !d.Has(path) {
+		return nil
+	}
+	if d.Has(path + ".attempts") {
+		if policy.Attempts, err = d.GetInt(path + ".attempts"); err != nil {
+			return err
+		}
+	}
+	if d.Has(path + ".initialDelay") {
+		if policy.InitialDelay, err = d.GetDuration(path + ".initialDelay"); err != nil {
+			return err
+		}
+	}
+	if d.Has(path + ".maxDelay") {
+		if policy.MaxDelay, err = d.GetDuration(path + ".maxDelay"); err != nil {
+			return err
+		}
+	}
+	if d.Has(path + ".multiplier") {
+		if policy.Multiplier, err = d.GetFloat(path + ".multiplier"); err != nil {
+			return err
+		}
+	}
+	if d.Has(path + ".jitter") {
+		if policy.Jitter, err = d.GetFloat(path + ".jitter"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,16 +6,34 @@ import (
 	"github.com/cretz/systrument/shell"
 	"github.com/cretz/systrument/util"
 	"github.com/hashicorp/go-version"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"time"
 )
 
+// The default time a single clone attempt may take
+var DefaultTimeout = 30 * time.Second
+
+// Failures that trying again won't fix, matched against stderr
+var permanentErrorMatch = regexp.MustCompile(`(?i)authentication failed|could not read (username|password)|` +
+	`invalid username or password|permission denied|repository .*not found|remote branch .* not found|` +
+	`couldn't find remote ref|not a git repository|already exists and is not an empty directory`)
+
 type Git struct {
 	*context.Context
+	// The time a single clone attempt may take. Zero means no timeout.
+	Timeout time.Duration
+	// The time a single pull attempt may take. Zero, the default, means no timeout since how long a
+	// pull takes depends on how far behind the dir is.
+	PullTimeout time.Duration
 }
 
 func NewGit(ctx *context.Context) *Git {
-	return &Git{ctx}
+	return &Git{Context: ctx, Timeout: DefaultTimeout}
 }
 
 func (g *Git) Version() (*version.Version, error) {
@@ -26,18 +44,34 @@ func (g *Git) Version() (*version.Version, error) {
 	return util.VersionAfterLastSpace(string(byts))
 }
 
+// Network failures and timeouts are retried per the "git" retry policy. Authentication and missing
+// repo or branch failures are not.
 func (g *Git) Clone(repo *Repo, intoDir string) error {
 	// TODO: --single-branch?
+	g.AddSecrets(repo.Secrets()...)
 	properUrl, err := repo.URLWithCredentials()
 	if err != nil {
 		return fmt.Errorf("Invalid URL: %v", err)
 	}
-	cmd := shell.WrapCommandOutput(g.Context, exec.Command("git", "clone", "-b", repo.Branch, properUrl, intoDir))
-	if err = cmd.Start(); err != nil {
-		return fmt.Errorf("Unable to clone: %v", err)
-	}
-	// Wait for completion
-	if err = shell.WaitTimeout(cmd, 30*time.Second); err != nil {
+	// A failed attempt can leave a partial clone behind which we have to remove. Git only clones into
+	// an existing dir if it's empty, so we can empty it again, otherwise we can only remove it if we
+	// made it.
+	_, err = os.Stat(intoDir)
+	existed := err == nil
+	existedEmpty := existed && isEmptyDir(intoDir)
+	err = g.retry(func(attempt int) error {
+		if attempt > 1 && existedEmpty {
+			if err := emptyDir(intoDir); err != nil {
+				return util.Permanent(fmt.Errorf("Unable to remove partial clone: %v", err))
+			}
+		} else if attempt > 1 && !existed {
+			if err := os.RemoveAll(intoDir); err != nil {
+				return util.Permanent(fmt.Errorf("Unable to remove partial clone: %v", err))
+			}
+		}
+		return g.run("", g.Timeout, "clone", "-b", repo.Branch, properUrl, intoDir)
+	})
+	if err != nil {
 		return fmt.Errorf("Failed clone: %v", err)
 	}
 	return nil
@@ -49,12 +83,65 @@ func (g *Git) ResetHard(dir string) error {
 	return cmd.Run()
 }
 
+// Retried like Clone
 func (g *Git) Pull(repo *Repo, dir string) error {
+	g.AddSecrets(repo.Secrets()...)
 	properUrl, err := repo.URLWithCredentials()
 	if err != nil {
 		return fmt.Errorf("Invalid URL: %v", err)
 	}
-	cmd := shell.WrapCommandOutput(g.Context, exec.Command("git", "pull", properUrl))
+	return g.retry(func(attempt int) error { return g.run(dir, g.PullTimeout, "pull", properUrl) })
+}
+
+func (g *Git) retry(fn func(attempt int) error) error {
+	retry, err := g.RetryPolicy("git")
+	if err != nil {
+		return err
+	}
+	retry.Retryable = func(err error) bool { return err != shell.ErrCancelled }
+	return retry.Do(fn)
+}
+
+func (g *Git) run(dir string, timeout time.Duration, args ...string) error {
+	cmd := shell.Command(g.Context, "git", args...)
 	cmd.Dir = dir
-	return cmd.Run()
+	_, err := shell.RunCommandResult(g.Context, cmd, &shell.RunOptions{Timeout: timeout})
+	return classifyError(err)
+}
+
+func classifyError(err error) error {
+	resErr, ok := err.(*shell.ResultError)
+	if !ok {
+		return err
+	}
+	if resErr.Err == shell.ErrCancelled {
+		return shell.ErrCancelled
+	}
+	if resErr.Err != shell.ErrTimeout && permanentErrorMatch.Match(resErr.Stderr) {
+		return util.Permanent(err)
+	}
+	return err
+}
+
+func isEmptyDir(dir string) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	return err == io.EOF
+}
+
+func emptyDir(dir string) error {
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range names {
+		if err := os.RemoveAll(filepath.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,42 @@
+package git
+
+import (
+	"errors"
+	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"network", &shell.ResultError{Result: &shell.Result{
+			Stderr: []byte("fatal: unable to access 'https://example.com/repo.git/': Could not resolve host")},
+			Err: errors.New("exit status 128")}, false},
+		{"auth", &shell.ResultError{Result: &shell.Result{
+			Stderr: []byte("fatal: Authentication failed for 'https://example.com/repo.git/'")},
+			Err: errors.New("exit status 128")}, true},
+		{"missing branch", &shell.ResultError{Result: &shell.Result{
+			Stderr: []byte("warning: Could not find remote branch nope to clone.\nfatal: Remote branch nope not found in upstream origin")},
+			Err: errors.New("exit status 128")}, true},
+		{"missing repo", &shell.ResultError{Result: &shell.Result{
+			Stderr: []byte("remote: Repository not found.\nfatal: repository 'https://example.com/repo.git/' not found")},
+			Err: errors.New("exit status 128")}, true},
+		{"dir not empty", &shell.ResultError{Result: &shell.Result{
+			Stderr: []byte("fatal: destination path 'repo' already exists and is not an empty directory.")},
+			Err: errors.New("exit status 128")}, true},
+		{"timeout", &shell.ResultError{Result: &shell.Result{Stderr: []byte("Permission denied")}, Err: shell.ErrTimeout}, false},
+		{"other", errors.New("failed to start"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, permanent := classifyError(test.err).(*util.PermanentError)
+			if permanent != test.permanent {
+				t.Fatalf("Expected permanent %v, got %v", test.permanent, permanent)
+			}
+		})
+	}
+}
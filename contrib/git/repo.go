@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
 type Repo struct {
@@ -38,3 +39,12 @@ func (r *Repo) URLWithCredentials() (string, error) {
 	}
 	return properUrl.String(), nil
 }
+
+// Values that must not be logged, including the escaped form embedded in the credential URL
+func (r *Repo) Secrets() []string {
+	if r.Pass == "" {
+		return nil
+	}
+	escaped := strings.TrimPrefix(url.UserPassword("", r.Pass).String(), ":")
+	return []string{r.Pass, escaped}
+}
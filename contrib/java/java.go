@@ -1,30 +1,349 @@
 package java
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/pkg"
 	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"github.com/hashicorp/go-version"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Where tarballs are extracted by default and distro packages put their JDKs
+var JVMDir = "/usr/lib/jvm"
+
+// Where JAVA_HOME is exported for login shells
+var ProfileScript = "/etc/profile.d/java.sh"
+
+// How long a tarball download attempt can take
+var DownloadTimeout = 10 * time.Minute
+
+// An OpenJDK install from distro packages or a tarball. The fields can be read from config via
+// data.UnmarshalKey.
 type Java struct {
+	*context.Context `json:"-"`
+	// The major version, e.g. 17
+	MajorVersion int `json:"version"`
+	// If set, a URL of a JDK .tar.gz to install instead of distro packages, or a path to one already
+	// on the target host (a local file has to be uploaded first, e.g. via the context's resources)
+	Tarball string `json:"tarball"`
+	// If set, the hex SHA-256 the tarball must have
+	Sha256 string `json:"sha256"`
+	// Where the tarball is extracted, defaults to "jdk-<major>" in JVMDir
+	InstallDir string `json:"installDir"`
+	// Only install the headless packages
+	Headless bool `json:"headless"`
+	// The priority given to alternatives, defaults to the major version
+	AlternativesPriority int `json:"alternativesPriority"`
+}
+
+func NewJava(ctx *context.Context, majorVersion int) *Java {
+	return &Java{Context: ctx, MajorVersion: majorVersion}
+}
+
+// Installs the JDK if that major version isn't already, makes it the default java, and exports
+// JAVA_HOME
+func (j *Java) Install() error {
+	if j.MajorVersion <= 0 {
+		return fmt.Errorf("Java major version required")
+	}
+	if j.installed() {
+		j.Debugf("Java %v already installed", j.MajorVersion)
+	} else if j.Tarball != "" {
+		if err := j.installTarball(); err != nil {
+			return err
+		}
+	} else if err := j.installPackages(); err != nil {
+		return err
+	}
+	home, err := j.Home()
+	if err != nil {
+		return err
+	}
+	if err := j.setAlternatives(home); err != nil {
+		return err
+	}
+	return j.exportHome(home)
+}
+
+// Only what's in the JDK dir counts, not any other java on the path
+func (j *Java) installed() bool {
+	if _, err := j.Home(); err != nil {
+		return false
+	}
+	vers, err := j.Version()
+	return err == nil && vers.Segments()[0] == j.MajorVersion
+}
+
+// The JDK dir for the major version, erroring if not installed
+func (j *Java) Home() (string, error) {
+	if j.Tarball != "" {
+		home := j.tarballDir()
+		if _, err := os.Stat(filepath.Join(home, "bin", "java")); err != nil {
+			return "", fmt.Errorf("Java not installed at %v", home)
+		}
+		return home, nil
+	}
+	// Named like java-17-openjdk-amd64 (Debian), java-17-openjdk (RHEL, Alpine), or java-1.8.0-openjdk
+	// for 8 on RHEL
+	patterns := []string{fmt.Sprintf("java-%v-openjdk*", j.MajorVersion)}
+	if j.MajorVersion == 8 {
+		patterns = append(patterns, "java-1.8.0-openjdk*")
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(JVMDir, pattern))
+		sort.Strings(matches)
+		for _, match := range matches {
+			if _, err := os.Stat(filepath.Join(match, "bin", "java")); err == nil {
+				return match, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Java %v not installed in %v", j.MajorVersion, JVMDir)
+}
+
+var javaVersionMatch = regexp.MustCompile(`version "([^"]+)"`)
+
+// The full version of the JDK for the major version, or of java on the path if not installed. Old
+// style versions like "1.8.0_392" become "8.0.392".
+func (j *Java) Version() (*version.Version, error) {
+	javaPath := "java"
+	if home, err := j.Home(); err == nil {
+		javaPath = filepath.Join(home, "bin", "java")
+	}
+	byts, err := shell.CombinedOutput(j.Context, javaPath, "-version")
+	if err != nil {
+		return nil, fmt.Errorf("Failed asking for java version: %v", err)
+	}
+	return parseVersion(byts)
+}
+
+func parseVersion(byts []byte) (*version.Version, error) {
+	match := javaVersionMatch.FindSubmatch(byts)
+	if match == nil {
+		return nil, fmt.Errorf("Unrecognized java version output: %v", string(byts))
+	}
+	str := string(match[1])
+	if strings.HasPrefix(str, "1.") {
+		str = strings.Replace(str[2:], "_", ".", 1)
+	}
+	return pkg.ParseVersion(str)
+}
+
+func (j *Java) installPackages() error {
+	packages := pkg.NewPackages(j.Context)
+	name, err := j.packageName(packages)
+	if err != nil {
+		return err
+	}
+	return packages.Install(name)
+}
+
+func (j *Java) packageName(packages *pkg.Packages) (string, error) {
+	manager, err := packages.Detect()
+	if err != nil {
+		return "", err
+	}
+	major := strconv.Itoa(j.MajorVersion)
+	switch manager.Name() {
+	case "apt":
+		if j.Headless {
+			return "openjdk-" + major + "-jdk-headless", nil
+		}
+		return "openjdk-" + major + "-jdk", nil
+	case "dnf", "yum":
+		if j.MajorVersion == 8 {
+			major = "1.8.0"
+		}
+		if j.Headless {
+			return "java-" + major + "-openjdk-headless", nil
+		}
+		return "java-" + major + "-openjdk-devel", nil
+	case "apk":
+		if j.Headless {
+			return "openjdk" + major + "-jre-headless", nil
+		}
+		return "openjdk" + major, nil
+	default:
+		return "", fmt.Errorf("No known OpenJDK package for %v", manager.Name())
+	}
+}
+
+func (j *Java) tarballDir() string {
+	if j.InstallDir != "" {
+		return j.InstallDir
+	}
+	return filepath.Join(JVMDir, "jdk-"+strconv.Itoa(j.MajorVersion))
+}
+
+// JDK tarballs have a single top-level dir which becomes the install dir
+func (j *Java) installTarball() error {
+	tarball := j.Tarball
+	if strings.HasPrefix(tarball, "http://") || strings.HasPrefix(tarball, "https://") {
+		tarball = filepath.Join(j.TempDir, "jdk.tar.gz")
+		if err := j.download(j.Tarball, tarball); err != nil {
+			return err
+		}
+	}
+	if err := j.verify(tarball); err != nil {
+		return err
+	}
+	installDir := j.tarballDir()
+	if err := os.MkdirAll(filepath.Dir(installDir), 0755); err != nil {
+		return fmt.Errorf("Unable to create dir for JDK: %v", err)
+	}
+	// Extracted beside the install dir so it can be renamed into place
+	extractDir, err := ioutil.TempDir(filepath.Dir(installDir), ".jdk-extract")
+	if err != nil {
+		return fmt.Errorf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(extractDir)
+	j.Infof("Extracting JDK to %v", installDir)
+	if err := util.ExtractTarballFile(tarball, extractDir); err != nil {
+		return fmt.Errorf("Unable to extract JDK: %v", err)
+	}
+	infos, err := ioutil.ReadDir(extractDir)
+	if err != nil {
+		return fmt.Errorf("Unable to read extracted JDK: %v", err)
+	} else if len(infos) != 1 || !infos[0].IsDir() {
+		return fmt.Errorf("Expected JDK tarball to have a single top-level dir")
+	}
+	if err := os.RemoveAll(installDir); err != nil {
+		return fmt.Errorf("Unable to remove previous JDK: %v", err)
+	}
+	if err := os.Rename(filepath.Join(extractDir, infos[0].Name()), installDir); err != nil {
+		return fmt.Errorf("Unable to move JDK into place: %v", err)
+	}
+	return nil
+}
+
+func (j *Java) download(url string, path string) error {
+	retry, err := j.RetryPolicy("download")
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: DownloadTimeout}
+	j.Infof("Downloading JDK from %v", url)
+	return retry.Do(func(attempt int) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return util.Permanent(fmt.Errorf("Invalid JDK URL: %v", err))
+		}
+		resp, err := client.Do(req.WithContext(j.StdContext()))
+		if err != nil && j.Err() != nil {
+			return util.Permanent(shell.ErrCancelled)
+		} else if err != nil {
+			return fmt.Errorf("Unable to download JDK: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Unable to download JDK: %v", resp.Status)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return util.Permanent(fmt.Errorf("Unable to create file: %v", err))
+		}
+		defer f.Close()
+		if _, err = io.Copy(f, resp.Body); err != nil && j.Err() != nil {
+			return util.Permanent(shell.ErrCancelled)
+		} else if err != nil {
+			return fmt.Errorf("Unable to download JDK: %v", err)
+		}
+		return nil
+	})
 }
 
-func (_ *Java) Install(ctx *context.Context) error {
-	// Add repo
-	if err := shell.Run(ctx, "add-apt-repository", "ppa:webupd8team/java"); err != nil {
-		return fmt.Errorf("Unable to add repo: %v", err)
+func (j *Java) verify(path string) error {
+	if j.Sha256 == "" {
+		return nil
 	}
-	if err := shell.Run(ctx, "apt-get", "update"); err != nil {
-		return fmt.Errorf("Unable to update apt: %v", err)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Unable to open JDK tarball: %v", err)
 	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("Unable to read JDK tarball: %v", err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, j.Sha256) {
+		return fmt.Errorf("JDK tarball has SHA-256 %v, expected %v", actual, j.Sha256)
+	}
+	return nil
+}
 
-	// Pre-accept the Oracle license and install
-	cmdLine := "echo 'oracle-java8-installer shared/accepted-oracle-license-v1-1 select true' | debconf-set-selections"
-	if err := shell.Run(ctx, "bash", "-c", cmdLine); err != nil {
-		return fmt.Errorf("Unable to accept Oracle license: %v", err)
+// Registers and selects java and javac via update-alternatives, or links them into /usr/bin where
+// there are no alternatives (e.g. Alpine)
+func (j *Java) setAlternatives(home string) error {
+	priority := j.AlternativesPriority
+	if priority == 0 {
+		priority = j.MajorVersion
 	}
-	if err := shell.Run(ctx, "apt-get", "install", "-y", "oracle-java8-installer"); err != nil {
-		return fmt.Errorf("Unable to install necessities: %v", err)
+	for _, name := range []string{"java", "javac"} {
+		path := filepath.Join(home, "bin", name)
+		if _, err := os.Stat(path); err != nil {
+			// Headless installs may not have javac
+			continue
+		}
+		link := filepath.Join("/usr/bin", name)
+		if !shell.HasCommand("update-alternatives") {
+			if target, err := os.Readlink(link); err == nil && target == path {
+				continue
+			}
+			os.Remove(link)
+			if err := os.Symlink(path, link); err != nil {
+				return fmt.Errorf("Unable to link %v: %v", link, err)
+			}
+			continue
+		}
+		err := shell.Run(j.Context, "update-alternatives", "--install", link, name, path, strconv.Itoa(priority))
+		if err != nil {
+			return fmt.Errorf("Unable to install %v alternative: %v", name, err)
+		}
+		// Setting one that isn't registered fails, e.g. when the link is managed elsewhere
+		if !alternativeRegistered(j.Context, name, path) {
+			j.Infof("%v is not a registered %v alternative, not selecting it", path, name)
+			continue
+		}
+		if err = shell.Run(j.Context, "update-alternatives", "--set", name, path); err != nil {
+			return fmt.Errorf("Unable to set %v alternative: %v", name, err)
+		}
 	}
 	return nil
 }
+
+func (j *Java) exportHome(home string) error {
+	script := "export JAVA_HOME=" + home + "\nexport PATH=\"$JAVA_HOME/bin:$PATH\"\n"
+	if changed, err := util.WriteFileIfChanged(ProfileScript, []byte(script), 0644); err != nil {
+		return fmt.Errorf("Unable to write %v: %v", ProfileScript, err)
+	} else if changed {
+		j.Debugf("Exported JAVA_HOME %v in %v", home, ProfileScript)
+	}
+	return nil
+}
+
+// Debian and RHEL both list each registered path at the start of a line of --display
+func alternativeRegistered(ctx *context.Context, name string, path string) bool {
+	byts, err := shell.Output(ctx, "update-alternatives", "--display", name)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(byts), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == path {
+			return true
+		}
+	}
+	return false
+}
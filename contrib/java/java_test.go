@@ -0,0 +1,111 @@
+package java
+
+import (
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/pkg"
+	"github.com/cretz/systrument/util"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type namedManager struct {
+	pkg.Manager
+	name string
+}
+
+func (n *namedManager) Name() string { return n.name }
+
+func TestPackageName(t *testing.T) {
+	tests := []struct {
+		manager  string
+		major    int
+		headless bool
+		expected string
+	}{
+		{"apt", 17, false, "openjdk-17-jdk"},
+		{"apt", 17, true, "openjdk-17-jdk-headless"},
+		{"apt", 8, false, "openjdk-8-jdk"},
+		{"dnf", 17, false, "java-17-openjdk-devel"},
+		{"dnf", 17, true, "java-17-openjdk-headless"},
+		{"dnf", 8, false, "java-1.8.0-openjdk-devel"},
+		{"yum", 8, true, "java-1.8.0-openjdk-headless"},
+		{"apk", 17, false, "openjdk17"},
+		{"apk", 17, true, "openjdk17-jre-headless"},
+		{"pacman", 17, false, ""},
+	}
+	for _, test := range tests {
+		j := &Java{MajorVersion: test.major, Headless: test.headless}
+		packages := &pkg.Packages{Manager: &namedManager{name: test.manager}}
+		actual, err := j.packageName(packages)
+		if test.expected == "" && err == nil {
+			t.Fatalf("Expected error for %v, got %v", test.manager, actual)
+		} else if test.expected != "" && (err != nil || actual != test.expected) {
+			t.Fatalf("Expected %v for %v %v (headless %v), got %v (%v)",
+				test.expected, test.manager, test.major, test.headless, actual, err)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{"1.8 openjdk", "openjdk version \"1.8.0_392\"\nOpenJDK Runtime Environment (build 1.8.0_392-b08)\n", "8.0.392"},
+		{"1.8 oracle", "java version \"1.8.0_201\"\nJava(TM) SE Runtime Environment (build 1.8.0_201-b09)\n", "8.0.201"},
+		{"17", "openjdk version \"17.0.9\" 2023-10-17\nOpenJDK Runtime Environment (build 17.0.9+9)\n", "17.0.9"},
+		{"17 GA", "openjdk version \"17\" 2021-09-14\n", "17.0.0"},
+		{"early access", "openjdk version \"21-ea\" 2023-09-19\n", "21.0.0"},
+		{"unrecognized", "bash: java: command not found\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vers, err := parseVersion([]byte(test.output))
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("Expected error, got %v", vers)
+				}
+				return
+			}
+			if err != nil || vers.String() != test.expected {
+				t.Fatalf("Expected %v, got %v (%v)", test.expected, vers, err)
+			}
+		})
+	}
+}
+
+func TestExportHome(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syst-java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(script string) { ProfileScript = script }(ProfileScript)
+	ProfileScript = filepath.Join(dir, "profile.d", "java.sh")
+	redactor := util.NewRedactor()
+	j := &Java{Context: &context.Context{
+		Logger:   util.GoLoggerWrapper(log.New(ioutil.Discard, "", 0), false, redactor),
+		Redactor: redactor,
+	}}
+	if err := j.exportHome("/usr/lib/jvm/jdk-17"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.Stat(ProfileScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.exportHome("/usr/lib/jvm/jdk-17"); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := os.Stat(ProfileScript); err != nil || !os.SameFile(first, again) {
+		t.Fatalf("Expected the unchanged script not to be rewritten (%v)", err)
+	}
+	byts, _ := ioutil.ReadFile(ProfileScript)
+	if expected := "export JAVA_HOME=/usr/lib/jvm/jdk-17\nexport PATH=\"$JAVA_HOME/bin:$PATH\"\n"; string(byts) != expected {
+		t.Fatalf("Expected %q, got %q", expected, byts)
+	}
+}
@@ -2,25 +2,39 @@ package nginx
 
 import (
 	"github.com/cretz/systrument/context"
-	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/service"
 )
 
 type Nginx struct {
 	*context.Context
+	Service *service.Service
 }
 
 func NewNginx(ctx *context.Context) *Nginx {
-	return &Nginx{ctx}
+	return &Nginx{ctx, service.NewService(ctx, "nginx")}
 }
 
 func (n *Nginx) Reload() error {
-	return shell.Run(n.Context, "service", "nginx", "reload")
+	return n.Service.Reload()
 }
 
 func (n *Nginx) Stop() error {
-	return shell.Run(n.Context, "service", "nginx", "stop")
+	return n.Service.Stop()
 }
 
 func (n *Nginx) Start() error {
-	return shell.Run(n.Context, "service", "nginx", "start")
+	return n.Service.Start()
+}
+
+func (n *Nginx) Restart() error {
+	return n.Service.Restart()
+}
+
+// Makes nginx start at boot
+func (n *Nginx) Enable() error {
+	return n.Service.Enable()
+}
+
+func (n *Nginx) Status() (*service.Status, error) {
+	return n.Service.Status()
 }
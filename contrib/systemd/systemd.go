@@ -0,0 +1,158 @@
+package systemd
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/facts"
+	"github.com/cretz/systrument/service"
+	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"os"
+	"path/filepath"
+)
+
+var DefaultUnitDir = "/etc/systemd/system"
+
+type Systemd struct {
+	*context.Context
+	UnitDir string
+}
+
+func NewSystemd(ctx *context.Context) *Systemd {
+	return &Systemd{ctx, DefaultUnitDir}
+}
+
+// Writes the units that differ from what's installed and reloads systemd if any did, even if a later
+// one fails. Environment entries with secrets in them are moved to an EnvironmentFile only root can
+// read since unit files are readable by everyone. Returns whether anything changed.
+func (s *Systemd) Install(units ...*Unit) (bool, error) {
+	changed := false
+	for _, unit := range units {
+		unitChanged, err := s.write(s.UnitDir, unit.Name, unit)
+		changed = changed || unitChanged
+		if err != nil {
+			return changed, s.reloadAfter(changed, fmt.Errorf("Unable to install unit %v: %v", unit.Name, err))
+		}
+	}
+	return changed, s.reloadIf(changed)
+}
+
+// Same as Install for a drop-in overriding part of the named unit. The drop-in's name is the file
+// name in the unit's ".d" dir and should end with ".conf".
+func (s *Systemd) InstallDropIn(unitName string, dropIn *Unit) (bool, error) {
+	changed, err := s.write(s.dropInDir(unitName), unitName, dropIn)
+	if err != nil {
+		err = fmt.Errorf("Unable to install drop-in %v for unit %v: %v", dropIn.Name, unitName, err)
+		return changed, s.reloadAfter(changed, err)
+	}
+	return changed, s.reloadIf(changed)
+}
+
+// Removes the installed units (and their drop-ins) if present, reloading systemd if any were.
+// Units should be stopped and disabled first.
+func (s *Systemd) Remove(unitNames ...string) (bool, error) {
+	changed := false
+	for _, unitName := range unitNames {
+		for _, path := range []string{filepath.Join(s.UnitDir, unitName), s.dropInDir(unitName)} {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				return changed, s.reloadAfter(changed, fmt.Errorf("Unable to remove %v: %v", path, err))
+			}
+			changed = true
+		}
+	}
+	return changed, s.reloadIf(changed)
+}
+
+func (s *Systemd) RemoveDropIn(unitName string, dropInName string) (bool, error) {
+	path := filepath.Join(s.dropInDir(unitName), dropInName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil {
+		return false, fmt.Errorf("Unable to remove %v: %v", path, err)
+	}
+	if err := removeIfExists(s.secretEnvPath(unitName, dropInName)); err != nil {
+		return true, s.reloadAfter(true, err)
+	}
+	return true, s.DaemonReload()
+}
+
+func (s *Systemd) DaemonReload() error {
+	return shell.Run(s.Context, "systemctl", "daemon-reload")
+}
+
+// The unit as a service to start, enable, etc. This works for timers too.
+func (s *Systemd) Service(unitName string) *service.Service {
+	return &service.Service{Context: s.Context, Name: unitName, InitSystem: facts.InitSystemd}
+}
+
+// Installs the service and a timer for it, then enables and starts the timer (not the service)
+func (s *Systemd) InstallTimer(svc *ServiceUnit, timer *TimerUnit) (bool, error) {
+	changed, err := s.Install(svc.ToUnit(), timer.ToUnit())
+	if err != nil {
+		return false, err
+	}
+	timerService := s.Service(timer.Name + ".timer")
+	if err = timerService.Enable(); err != nil {
+		return changed, err
+	}
+	if changed {
+		return changed, timerService.Restart()
+	}
+	return changed, timerService.Start()
+}
+
+func (s *Systemd) dropInDir(unitName string) string {
+	return filepath.Join(s.UnitDir, unitName+".d")
+}
+
+// Where the Environment entries with secrets of the unit or drop-in file go
+func (s *Systemd) secretEnvPath(unitName string, fileName string) string {
+	return filepath.Join(s.dropInDir(unitName), fileName+".env")
+}
+
+// Writes the unit or drop-in to the dir and its secret environment file, or removes that if it no
+// longer has secrets
+func (s *Systemd) write(dir string, unitName string, u *Unit) (bool, error) {
+	envPath := s.secretEnvPath(unitName, u.Name)
+	u, env := moveSecretEnvironment(u, s.Context, envPath)
+	changed := false
+	if env != nil {
+		envChanged, err := util.WriteFileIfChanged(envPath, env, 0600)
+		if err != nil {
+			return false, err
+		}
+		changed = envChanged
+	}
+	unitChanged, err := util.WriteFileIfChanged(filepath.Join(dir, u.Name), u.Render(), 0644)
+	changed = changed || unitChanged
+	if err != nil || env != nil {
+		return changed, err
+	}
+	return changed, removeIfExists(envPath)
+}
+
+func (s *Systemd) reloadIf(changed bool) error {
+	if !changed {
+		return nil
+	}
+	return s.DaemonReload()
+}
+
+// Reloads if anything changed before the error so systemd doesn't keep stale units
+func (s *Systemd) reloadAfter(changed bool, err error) error {
+	if reloadErr := s.reloadIf(changed); reloadErr != nil {
+		return fmt.Errorf("%v (reloading after failed too: %v)", err, reloadErr)
+	}
+	return err
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to remove %v: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package systemd
+
+import (
+	"sort"
+	"strings"
+)
+
+// Common settings of a ".service" unit. Anything else can be set on the resulting unit.
+type ServiceUnit struct {
+	// Without the ".service" suffix
+	Name             string            `json:"name"`
+	Description      string            `json:"description"`
+	After            []string          `json:"after"`
+	Wants            []string          `json:"wants"`
+	Requires         []string          `json:"requires"`
+	Type             string            `json:"type"`
+	User             string            `json:"user"`
+	Group            string            `json:"group"`
+	WorkingDirectory string            `json:"workingDirectory"`
+	Environment      map[string]string `json:"environment"`
+	EnvironmentFile  string            `json:"environmentFile"`
+	ExecStartPre     []string          `json:"execStartPre"`
+	ExecStart        string            `json:"execStart"`
+	ExecReload       string            `json:"execReload"`
+	ExecStop         string            `json:"execStop"`
+	// E.g. "always" or "on-failure"
+	Restart     string `json:"restart"`
+	RestartSec  string `json:"restartSec"`
+	LimitNOFILE string `json:"limitNOFILE"`
+	// Defaults to "multi-user.target" if empty
+	WantedBy []string `json:"wantedBy"`
+}
+
+func (s *ServiceUnit) ToUnit() *Unit {
+	u := NewUnit(s.Name + ".service")
+	setIf(u, "Unit", "Description", s.Description)
+	setIf(u, "Unit", "After", strings.Join(s.After, " "))
+	setIf(u, "Unit", "Wants", strings.Join(s.Wants, " "))
+	setIf(u, "Unit", "Requires", strings.Join(s.Requires, " "))
+	setIf(u, "Service", "Type", s.Type)
+	setIf(u, "Service", "User", s.User)
+	setIf(u, "Service", "Group", s.Group)
+	setIf(u, "Service", "WorkingDirectory", s.WorkingDirectory)
+	// Sorted so the file doesn't change between runs
+	envKeys := make([]string, 0, len(s.Environment))
+	for key := range s.Environment {
+		envKeys = append(envKeys, key)
+	}
+	sort.Strings(envKeys)
+	for _, key := range envKeys {
+		u.Add("Service", "Environment", quote(key+"="+s.Environment[key]))
+	}
+	setIf(u, "Service", "EnvironmentFile", s.EnvironmentFile)
+	for _, pre := range s.ExecStartPre {
+		u.Add("Service", "ExecStartPre", pre)
+	}
+	setIf(u, "Service", "ExecStart", s.ExecStart)
+	setIf(u, "Service", "ExecReload", s.ExecReload)
+	setIf(u, "Service", "ExecStop", s.ExecStop)
+	setIf(u, "Service", "Restart", s.Restart)
+	setIf(u, "Service", "RestartSec", s.RestartSec)
+	setIf(u, "Service", "LimitNOFILE", s.LimitNOFILE)
+	wantedBy := s.WantedBy
+	if len(wantedBy) == 0 {
+		wantedBy = []string{"multi-user.target"}
+	}
+	u.Set("Install", "WantedBy", strings.Join(wantedBy, " "))
+	return u
+}
+
+// A ".timer" unit that triggers a service
+type TimerUnit struct {
+	// Without the ".timer" suffix
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// E.g. "daily" or "*-*-* 04:00:00"
+	OnCalendar      string `json:"onCalendar"`
+	OnBootSec       string `json:"onBootSec"`
+	OnUnitActiveSec string `json:"onUnitActiveSec"`
+	// Run on start if a calendar time was missed while off
+	Persistent         bool   `json:"persistent"`
+	RandomizedDelaySec string `json:"randomizedDelaySec"`
+	// The unit to trigger, defaults to the service of the same name
+	Unit string `json:"unit"`
+	// Defaults to "timers.target" if empty
+	WantedBy []string `json:"wantedBy"`
+}
+
+func (t *TimerUnit) ToUnit() *Unit {
+	u := NewUnit(t.Name + ".timer")
+	setIf(u, "Unit", "Description", t.Description)
+	setIf(u, "Timer", "OnCalendar", t.OnCalendar)
+	setIf(u, "Timer", "OnBootSec", t.OnBootSec)
+	setIf(u, "Timer", "OnUnitActiveSec", t.OnUnitActiveSec)
+	if t.Persistent {
+		u.Set("Timer", "Persistent", yesNo(t.Persistent))
+	}
+	setIf(u, "Timer", "RandomizedDelaySec", t.RandomizedDelaySec)
+	setIf(u, "Timer", "Unit", t.Unit)
+	wantedBy := t.WantedBy
+	if len(wantedBy) == 0 {
+		wantedBy = []string{"timers.target"}
+	}
+	u.Set("Install", "WantedBy", strings.Join(wantedBy, " "))
+	return u
+}
+
+func setIf(u *Unit, section string, key string, value string) {
+	if value != "" {
+		u.Set(section, key, value)
+	}
+}
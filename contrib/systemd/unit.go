@@ -0,0 +1,275 @@
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/util"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A unit file (or drop-in) as ordered sections of entries. Keys may repeat (e.g. ExecStartPre).
+type Unit struct {
+	// The file name, e.g. "myapp.service" or for a drop-in "override.conf"
+	Name     string
+	Sections []*Section
+}
+
+type Section struct {
+	Name    string
+	Entries []*Entry
+}
+
+type Entry struct {
+	Key   string
+	Value string
+}
+
+func NewUnit(name string) *Unit {
+	return &Unit{Name: name}
+}
+
+func (u *Unit) Section(name string) *Section {
+	for _, section := range u.Sections {
+		if section.Name == name {
+			return section
+		}
+	}
+	section := &Section{Name: name}
+	u.Sections = append(u.Sections, section)
+	return section
+}
+
+// Replaces all entries for the key with the value
+func (u *Unit) Set(section string, key string, value string) *Unit {
+	s := u.Section(section)
+	entries := s.Entries[:0]
+	for _, entry := range s.Entries {
+		if entry.Key != key {
+			entries = append(entries, entry)
+		}
+	}
+	s.Entries = append(entries, &Entry{key, value})
+	return u
+}
+
+// Adds an entry even if the key is already present
+func (u *Unit) Add(section string, key string, value string) *Unit {
+	s := u.Section(section)
+	s.Entries = append(s.Entries, &Entry{key, value})
+	return u
+}
+
+func (u *Unit) Render() []byte {
+	var b bytes.Buffer
+	for i, section := range u.Sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%v]\n", section.Name)
+		for _, entry := range section.Entries {
+			fmt.Fprintf(&b, "%v=%v\n", entry.Key, entry.Value)
+		}
+	}
+	return b.Bytes()
+}
+
+// Builds a unit from config data in the form of section name to key to value. Values may be strings,
+// numbers, bools (as yes/no), or arrays of them for repeated keys. Keys are sorted and sections are
+// in the usual order ("Unit" first, "Install" last, others by name) so the result is stable.
+func UnitFromMap(name string, m map[string]interface{}) (*Unit, error) {
+	sectionNames := make([]string, 0, len(m))
+	for sectionName := range m {
+		sectionNames = append(sectionNames, sectionName)
+	}
+	sort.Slice(sectionNames, func(i, j int) bool {
+		return sectionOrder(sectionNames[i]) < sectionOrder(sectionNames[j]) ||
+			(sectionOrder(sectionNames[i]) == sectionOrder(sectionNames[j]) && sectionNames[i] < sectionNames[j])
+	})
+	u := NewUnit(name)
+	for _, sectionName := range sectionNames {
+		entries, ok := m[sectionName].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Expected section %v of unit %v to be a map", sectionName, name)
+		}
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		u.Section(sectionName)
+		for _, key := range keys {
+			values, ok := entries[key].([]interface{})
+			if !ok {
+				values = []interface{}{entries[key]}
+			}
+			for _, value := range values {
+				str, err := unitValue(value)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid value for %v.%v of unit %v: %v", sectionName, key, name, err)
+				}
+				u.Add(sectionName, key, str)
+			}
+		}
+	}
+	return u, nil
+}
+
+// Builds units from config at the path holding a map of unit file name to sections as accepted by
+// UnitFromMap. The units are sorted by name.
+func UnitsFromConfig(d *data.Data, path string) ([]*Unit, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expected %v to be a map of unit names to sections", path)
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	units := make([]*Unit, len(names))
+	for i, name := range names {
+		sections, ok := m[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Expected unit %v in %v to be a map of sections", name, path)
+		}
+		if units[i], err = UnitFromMap(name, sections); err != nil {
+			return nil, err
+		}
+	}
+	return units, nil
+}
+
+func sectionOrder(name string) int {
+	switch name {
+	case "Unit":
+		return 0
+	case "Install":
+		return 2
+	default:
+		return 1
+	}
+}
+
+func unitValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return yesNo(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	default:
+		return "", fmt.Errorf("Unsupported value %v", v)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Quotes the value if it has anything systemd would otherwise split on and escapes "%" so systemd
+// doesn't take it as a specifier
+func quote(s string) string {
+	s = strings.Replace(s, "%", "%%", -1)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// Copies the unit without the Environment entries that have secrets in them, giving them back as
+// the contents of an EnvironmentFile at the path which the copy refers to instead. The unit and nil
+// are returned if there are none.
+func moveSecretEnvironment(u *Unit, redactor util.Redactor, envPath string) (*Unit, []byte) {
+	var env bytes.Buffer
+	ret := &Unit{Name: u.Name}
+	for _, section := range u.Sections {
+		retSection := ret.Section(section.Name)
+		for _, entry := range section.Entries {
+			if entry.Key != "Environment" || !hasSecret(redactor, splitEnvironment(entry.Value)) {
+				retSection.Entries = append(retSection.Entries, entry)
+				continue
+			}
+			for _, assignment := range splitEnvironment(entry.Value) {
+				if pieces := strings.SplitN(assignment, "=", 2); len(pieces) == 2 {
+					fmt.Fprintf(&env, "%v=%v\n", pieces[0], envFileQuote(pieces[1]))
+				}
+			}
+		}
+	}
+	if env.Len() == 0 {
+		return u, nil
+	}
+	return ret.Add("Service", "EnvironmentFile", envPath), env.Bytes()
+}
+
+func hasSecret(redactor util.Redactor, strs []string) bool {
+	for _, str := range strs {
+		if redactor.Redact(str) != str {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits the value of an Environment entry into its assignments, unquoted and with specifier
+// escapes undone since environment files don't have specifiers
+func splitEnvironment(value string) []string {
+	var assignments []string
+	var current bytes.Buffer
+	inQuotes, escaped, started := false, false, false
+	for _, r := range value {
+		switch {
+		case escaped:
+			current.WriteRune(unescapeRune(r))
+			escaped = false
+		case inQuotes && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes, started = !inQuotes, true
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if started {
+				assignments = append(assignments, strings.Replace(current.String(), "%%", "%", -1))
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		assignments = append(assignments, strings.Replace(current.String(), "%%", "%", -1))
+	}
+	return assignments
+}
+
+func unescapeRune(r rune) rune {
+	switch r {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	default:
+		return r
+	}
+}
+
+// Newlines are allowed as is in double quotes
+var envFileEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "`", "\\`", "$", "\\$")
+
+func envFileQuote(value string) string {
+	return "\"" + envFileEscaper.Replace(value) + "\""
+}
@@ -0,0 +1,63 @@
+package systemd
+
+import (
+	"github.com/cretz/systrument/util"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected string
+	}{
+		{"A=b", "A=b"},
+		{"A=b c", `"A=b c"`},
+		{"A=100%", "A=100%%"},
+		{`A=say "hi" 50%`, `"A=say \"hi\" 50%%"`},
+		{"", `""`},
+	}
+	for _, test := range tests {
+		if actual := quote(test.str); actual != test.expected {
+			t.Fatalf("Expected %v, got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestMoveSecretEnvironment(t *testing.T) {
+	redactor := util.NewRedactor()
+	redactor.AddSecrets("hunter22", "pa ss%$")
+	tests := []struct {
+		name    string
+		env     map[string]string
+		unitEnv []string
+		envFile string
+		moved   bool
+	}{
+		{"no secrets", map[string]string{"A": "b"}, []string{"A=b"}, "", false},
+		{"secret", map[string]string{"A": "b", "PASS": "hunter22"}, []string{"A=b"}, "PASS=\"hunter22\"\n", true},
+		{"quoted secret", map[string]string{"PASS": "pa ss%$"}, nil, "PASS=\"pa ss%\\$\"\n", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, env := moveSecretEnvironment((&ServiceUnit{Name: "app", Environment: test.env}).ToUnit(),
+				redactor, "/etc/app.env")
+			if string(env) != test.envFile {
+				t.Fatalf("Expected environment file %q, got %q", test.envFile, env)
+			}
+			unitEnv, envFiles := []string{}, []string{}
+			for _, entry := range u.Section("Service").Entries {
+				if entry.Key == "Environment" {
+					unitEnv = append(unitEnv, entry.Value)
+				} else if entry.Key == "EnvironmentFile" {
+					envFiles = append(envFiles, entry.Value)
+				}
+			}
+			if len(unitEnv) != len(test.unitEnv) || (len(unitEnv) > 0 && unitEnv[0] != test.unitEnv[0]) {
+				t.Fatalf("Expected environment %v, got %v", test.unitEnv, unitEnv)
+			}
+			if test.moved != (len(envFiles) == 1 && envFiles[0] == "/etc/app.env") {
+				t.Fatalf("Expected environment file referenced %v, got %v", test.moved, envFiles)
+			}
+		})
+	}
+}
@@ -4,17 +4,40 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/cretz/systrument/resource"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
 	"text/template"
+	"text/template/parse"
 )
 
 type UnmarshalFunc func([]byte, interface{}) error
 
 type Data struct {
 	Values map[string]interface{}
+	// Every value merged in, in the order it was applied
+	Sources []*ValueSource
+	// Used by template functions reading files. If nil, local resources are used.
+	Resources resource.Resources
+	// Used by template functions prompting. If nil, a prompter without answers is used.
+	Prompter *Prompter
+	// If set, given to templates as "self" so they can reference the final values of a previous pass
+	Self map[string]interface{}
+	// Facts about this host once gathered by the facts package, kept apart from the values so they
+	// never clash with config
+	Facts map[string]interface{}
+	// Registered via RegisterFuncs
+	funcs template.FuncMap
+	// Random values by scope, see SetRandomScope
+	randoms     map[string][]string
+	randomScope string
+	randomIndex int
 }
 
 func NewData() *Data {
-	return &Data{map[string]interface{}{}}
+	return &Data{Values: map[string]interface{}{}}
 }
 
 func DataFromObj(v interface{}) error {
@@ -48,11 +71,35 @@ func (d *Data) ApplyTemplate(byts []byte) ([]byte, error) {
 }
 
 func (d *Data) ApplyTemplateWithDelims(byts []byte, leftDelim string, rightDelim string) ([]byte, error) {
-	tmpl, err := template.New("data").Delims(leftDelim, rightDelim).Funcs(funcMap).Parse(string(byts))
+	return d.ApplyTemplateWithValues(byts, leftDelim, rightDelim, nil)
+}
+
+// Same as ApplyTemplate but referencing a missing map key is an error instead of "<no value>". The
+// index function can still be used for optional keys.
+func (d *Data) ApplyTemplateStrict(byts []byte) ([]byte, error) {
+	return d.applyTemplate(byts, "{{", "}}", nil, "missingkey=error")
+}
+
+// Same as ApplyTemplateWithDelims but with extra top-level template values alongside "prev"
+func (d *Data) ApplyTemplateWithValues(byts []byte, leftDelim string, rightDelim string,
+	extra map[string]interface{}) ([]byte, error) {
+	return d.applyTemplate(byts, leftDelim, rightDelim, extra, "missingkey=default")
+}
+
+func (d *Data) applyTemplate(byts []byte, leftDelim string, rightDelim string,
+	extra map[string]interface{}, option string) ([]byte, error) {
+	tmpl, err := template.New("data").Delims(leftDelim, rightDelim).Funcs(d.Funcs()).Option(option).
+		Parse(string(byts))
 	if err != nil {
 		return nil, fmt.Errorf("Invalid template: %v", err)
 	}
 	tmplValues := map[string]interface{}{"prev": d.Values}
+	if d.Self != nil {
+		tmplValues["self"] = d.Self
+	}
+	for key, val := range extra {
+		tmplValues[key] = val
+	}
 	buf := &bytes.Buffer{}
 	if err := tmpl.Execute(buf, tmplValues); err != nil {
 		return nil, fmt.Errorf("Unable to execute template: %v", err)
@@ -60,67 +107,341 @@ func (d *Data) ApplyTemplateWithDelims(byts []byte, leftDelim string, rightDelim
 	return buf.Bytes(), nil
 }
 
+// Whether the template references the top-level value of the given name (e.g. "self" for .self or
+// $.self). Comments and strings mentioning it don't count.
+func (d *Data) TemplateReferences(byts []byte, name string) (bool, error) {
+	referenced := false
+	err := d.walkTemplateFields(byts, func(ident []string) {
+		referenced = referenced || ident[0] == name
+	})
+	return referenced, err
+}
+
+// The paths of fields the template references directly under the top-level value of the given name
+// (e.g. [app port] for .self.app.port or $.self.app.port). Values only reached through functions
+// like "index" or relative to "with" or "range" aren't included.
+func (d *Data) TemplateFieldPaths(byts []byte, name string) ([][]string, error) {
+	var paths [][]string
+	err := d.walkTemplateFields(byts, func(ident []string) {
+		if len(ident) > 1 && ident[0] == name {
+			paths = append(paths, ident[1:])
+		}
+	})
+	return paths, err
+}
+
+// Calls visit with the identifiers of every field chain from the root, with the leading "$" of
+// variables removed
+func (d *Data) walkTemplateFields(byts []byte, visit func(ident []string)) error {
+	tmpl, err := template.New("data").Funcs(d.Funcs()).Parse(string(byts))
+	if err != nil {
+		return fmt.Errorf("Invalid template: %v", err)
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walkFields(t.Tree.Root, visit)
+		}
+	}
+	return nil
+}
+
+func walkFields(node parse.Node, visit func(ident []string)) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			walkFields(child, visit)
+		}
+	case *parse.ActionNode:
+		walkFields(node.Pipe, visit)
+	case *parse.IfNode:
+		walkBranchFields(&node.BranchNode, visit)
+	case *parse.RangeNode:
+		walkBranchFields(&node.BranchNode, visit)
+	case *parse.WithNode:
+		walkBranchFields(&node.BranchNode, visit)
+	case *parse.TemplateNode:
+		walkFields(node.Pipe, visit)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			walkFields(cmd, visit)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			walkFields(arg, visit)
+		}
+	case *parse.ChainNode:
+		walkFields(node.Node, visit)
+	case *parse.FieldNode:
+		visit(node.Ident)
+	case *parse.VariableNode:
+		if len(node.Ident) > 1 && node.Ident[0] == "$" {
+			visit(node.Ident[1:])
+		}
+	}
+}
+
+func walkBranchFields(node *parse.BranchNode, visit func(ident []string)) {
+	walkFields(node.Pipe, visit)
+	walkFields(node.List, visit)
+	walkFields(node.ElseList, visit)
+}
+
+// Makes random template functions give the same values, in call order, every time templates are
+// applied in the scope (e.g. each time a config file is rendered again). Empty, the default, is
+// always random.
+func (d *Data) SetRandomScope(scope string) {
+	d.randomScope, d.randomIndex = scope, 0
+}
+
+func (d *Data) randomPassword(length interface{}, seed ...string) (string, error) {
+	if d.randomScope == "" {
+		return randomPassword(length, seed...)
+	}
+	n, err := toInt(length)
+	if err != nil {
+		return "", err
+	}
+	index := d.randomIndex
+	d.randomIndex++
+	prev := d.randoms[d.randomScope]
+	if index < len(prev) && len(prev[index]) == n {
+		return prev[index], nil
+	}
+	str, err := randomPassword(n, seed...)
+	if err != nil {
+		return "", err
+	}
+	if d.randoms == nil {
+		d.randoms = map[string][]string{}
+	}
+	if index < len(prev) {
+		prev[index] = str
+	} else {
+		d.randoms[d.randomScope] = append(prev, str)
+	}
+	return str, nil
+}
+
+// A copy with its own values and sources that shares everything else
+func (d *Data) Copy() *Data {
+	ret := *d
+	ret.Values = copyValue(d.Values).(map[string]interface{})
+	ret.Sources = append([]*ValueSource(nil), d.Sources...)
+	return &ret
+}
+
+// Deep copies maps and slices so changes to one don't show up in the other
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			ret[key] = copyValue(val)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, val := range v {
+			ret[i] = copyValue(val)
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+// Clears all values and sources, keeping everything else
+func (d *Data) Reset() {
+	d.Values = map[string]interface{}{}
+	d.Sources = nil
+}
+
+// Registers custom template functions for all templates applied by this data, overriding any
+// existing functions of the same name. This should be called before any config is loaded.
+func (d *Data) RegisterFuncs(funcs ...template.FuncMap) {
+	if d.funcs == nil {
+		d.funcs = template.FuncMap{}
+	}
+	for _, fmap := range funcs {
+		for key, val := range fmap {
+			d.funcs[key] = val
+		}
+	}
+}
+
+// Obtains the built-in, prompt, resource, and registered template functions
+func (d *Data) Funcs() template.FuncMap {
+	resources := d.Resources
+	if resources == nil {
+		resources = resource.LocalResources()
+	}
+	prompter := d.Prompter
+	if prompter == nil {
+		prompter = NewPrompter(nil)
+	}
+	return mergeFuncMaps(funcMap, template.FuncMap{"randomPassword": d.randomPassword}, PromptFuncs(prompter),
+		ResourceFuncs(resources), d.funcs)
+}
+
+// Applies a template with the given value as the dot, using all of this data's functions
+func (d *Data) ExecuteTemplate(name string, byts []byte, v interface{}) ([]byte, error) {
+	return ApplyTemplate(name, byts, v, d.Funcs())
+}
+
 func (d *Data) ApplyTemplateAndJSONMerge(byts []byte) error {
 	return d.ApplyTemplateAndMerge(byts, json.Unmarshal)
 }
 
 func (d *Data) ApplyTemplateAndMerge(byts []byte, unmarshal UnmarshalFunc) error {
-	byts, err := d.ApplyTemplate(byts)
+	newValues, err := d.ApplyTemplateAndUnmarshal(byts, unmarshal)
 	if err != nil {
 		return err
 	}
+	return d.Merge(newValues)
+}
+
+// Applies the template with the current values as "prev" and unmarshals the result without
+// merging it
+func (d *Data) ApplyTemplateAndUnmarshal(byts []byte, unmarshal UnmarshalFunc) (map[string]interface{}, error) {
+	byts, err := d.ApplyTemplate(byts)
+	if err != nil {
+		return nil, err
+	}
 	newValues := map[string]interface{}{}
 	if err := unmarshal(byts, &newValues); err != nil {
-		return fmt.Errorf("Unable to unmarshal resulting text: %v", err)
+		return nil, fmt.Errorf("Unable to unmarshal resulting text: %v", err)
 	}
-	// Merge it
-	applyMap(d.Values, newValues)
-	return nil
+	return newValues, nil
 }
 
-func applyMap(existing map[string]interface{}, newValues map[string]interface{}) {
-	for k, v := range newValues {
-		if oldValue, ok := existing[k]; !ok {
-			existing[k] = v
-		} else {
-			// Rules:
-			//  arrays append to arrays
-			//  maps append to maps
-			//  everything else overrides
-			switch oldTypedVal := oldValue.(type) {
-			case map[string]interface{}:
-				if newMap, ok := v.(map[string]interface{}); ok {
-					applyMap(oldTypedVal, newMap)
-				} else {
-					existing[k] = v
-				}
-			case []interface{}:
-				if newSlice, ok := v.([]interface{}); ok {
-					existing[k] = append(oldTypedVal, newSlice)
-				} else {
-					existing[k] = v
-				}
-			default:
-				existing[k] = v
-			}
-		}
-	}
+func (d *Data) Merge(newValues map[string]interface{}) error {
+	return d.MergeFrom(newValues, "", nil)
+}
+
+// Merges the values recording the file they came from. The lines are optional and keyed by
+// dotted path.
+func (d *Data) MergeFrom(newValues map[string]interface{}, file string, lines map[string]int) error {
+	return d.MergeFromIncluded(newValues, file, "", lines)
+}
+
+// Same as MergeFrom for a file included by another, which is recorded too
+func (d *Data) MergeFromIncluded(newValues map[string]interface{}, file string, includedFrom string,
+	lines map[string]int) error {
+	sources := []*ValueSource{}
+	err := applyMap(d.Values, newValues, "", func(path string, action string, v interface{}) {
+		sources = append(sources, &ValueSource{
+			Path:         path,
+			File:         file,
+			IncludedFrom: includedFrom,
+			Line:         lines[path],
+			Action:       action,
+			Value:        v,
+		})
+	})
+	// Map iteration order is random, so keep each merge's sources stable
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Path < sources[j].Path })
+	d.Sources = append(d.Sources, sources...)
+	return err
 }
 
 var funcMap = template.FuncMap{
-	"hiddenPrompt": hiddenPrompt,
-	"jsonString":   jsonString,
-	"jsonVal":      jsonVal,
+	// Strings
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"title":      title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": trimPrefix,
+	"trimSuffix": trimSuffix,
+	"replace":    replace,
+	"split":      split,
+	"join":       join,
+	"contains":   contains,
+	"hasPrefix":  hasPrefix,
+	"hasSuffix":  hasSuffix,
+	"repeat":     repeat,
+	"indent":     indent,
+	"quote":      quote,
+	"toString":   toString,
+	// Defaults
+	"default":  defaultVal,
+	"coalesce": coalesce,
+	"empty":    empty,
+	"ternary":  ternary,
+	"required": required,
+	// Paths and URLs
+	"pathJoin":       pathJoin,
+	"pathBase":       path.Base,
+	"pathDir":        path.Dir,
+	"pathExt":        path.Ext,
+	"urlParse":       urlParse,
+	"urlJoin":        urlJoin,
+	"urlQueryEscape": url.QueryEscape,
+	"urlPathEscape":  url.PathEscape,
+	// Encoding and hashing
+	"b64enc":     b64enc,
+	"b64dec":     b64dec,
+	"hexEnc":     hexEnc,
+	"hexDec":     hexDec,
+	"md5":        md5Sum,
+	"sha1":       sha1Sum,
+	"sha256":     sha256Sum,
+	"jsonString": jsonString,
+	"jsonVal":    jsonVal,
+	"toYaml":     toYaml,
+	"toToml":     toToml,
+	// Environment
+	"env":         env,
+	"requiredEnv": requiredEnv,
+	// Networking
+	"cidrHost":     cidrHost,
+	"cidrNetmask":  cidrNetmask,
+	"cidrSubnet":   cidrSubnet,
+	"cidrContains": cidrContains,
+	// Random
+	"randomPassword": randomPassword,
 }
 
-func ApplyTemplate(name string, byts []byte, v interface{}, funcs ...template.FuncMap) ([]byte, error) {
-	newFuncMap := template.FuncMap{}
-	for _, fmap := range append([]template.FuncMap{funcMap}, funcs...) {
-		for key, val := range fmap {
-			newFuncMap[key] = val
-		}
+// Functions that prompt the user (or use the answers): prompt, promptDefault, promptChoice, and
+// hiddenPrompt
+func PromptFuncs(p *Prompter) template.FuncMap {
+	return template.FuncMap{
+		"prompt": func(text string) (string, error) {
+			return p.Prompt(&PromptRequest{Text: text})
+		},
+		"promptDefault": func(def string, text string) (string, error) {
+			return p.Prompt(&PromptRequest{Text: text, Default: def})
+		},
+		"promptChoice": func(text string, choices ...string) (string, error) {
+			return p.Prompt(&PromptRequest{Text: text, Choices: choices})
+		},
+		"hiddenPrompt": func(text string) (string, error) {
+			return p.Prompt(&PromptRequest{Text: text, Hidden: true})
+		},
 	}
-	tmpl, err := template.New(name).Funcs(newFuncMap).Parse(string(byts))
+}
+
+// Functions that need resources to read local files
+func ResourceFuncs(r resource.Resources) template.FuncMap {
+	return template.FuncMap{
+		"readFile": func(localPath string) (string, error) {
+			byts, err := r.ReadFile(localPath)
+			if err != nil {
+				return "", fmt.Errorf("Unable to read file %v: %v", localPath, err)
+			}
+			return string(byts), nil
+		},
+	}
+}
+
+func ApplyTemplate(name string, byts []byte, v interface{}, funcs ...template.FuncMap) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(mergeFuncMaps(append([]template.FuncMap{funcMap}, funcs...)...)).
+		Parse(string(byts))
 	if err != nil {
 		return nil, err
 	}
@@ -130,3 +451,14 @@ func ApplyTemplate(name string, byts []byte, v interface{}, funcs ...template.Fu
 	}
 	return buf.Bytes(), nil
 }
+
+// Later maps override earlier ones
+func mergeFuncMaps(funcs ...template.FuncMap) template.FuncMap {
+	ret := template.FuncMap{}
+	for _, fmap := range funcs {
+		for key, val := range fmap {
+			ret[key] = val
+		}
+	}
+	return ret
+}
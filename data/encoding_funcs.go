@@ -0,0 +1,108 @@
+package data
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"path"
+)
+
+func b64enc(str string) string {
+	return base64.StdEncoding.EncodeToString([]byte(str))
+}
+
+func b64dec(str string) (string, error) {
+	byts, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return "", fmt.Errorf("Invalid base64: %v", err)
+	}
+	return string(byts), nil
+}
+
+func hexEnc(str string) string {
+	return hex.EncodeToString([]byte(str))
+}
+
+func hexDec(str string) (string, error) {
+	byts, err := hex.DecodeString(str)
+	if err != nil {
+		return "", fmt.Errorf("Invalid hex: %v", err)
+	}
+	return string(byts), nil
+}
+
+func md5Sum(str string) string {
+	sum := md5.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha1Sum(str string) string {
+	sum := sha1.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Sum(str string) string {
+	sum := sha256.Sum256([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+func toYaml(v interface{}) (string, error) {
+	byts, err := yaml.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("Unable to convert to YAML: %v", err)
+	}
+	return string(bytes.TrimSuffix(byts, []byte("\n"))), nil
+}
+
+func toToml(v interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(v); err != nil {
+		return "", fmt.Errorf("Unable to convert to TOML: %v", err)
+	}
+	return buf.String(), nil
+}
+
+func pathJoin(elems ...string) string {
+	return path.Join(elems...)
+}
+
+// Gives a map with scheme, host, hostname, port, path, query, fragment, and user keys
+func urlParse(str string) (map[string]interface{}, error) {
+	u, err := url.Parse(str)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL: %v", err)
+	}
+	user := ""
+	if u.User != nil {
+		user = u.User.Username()
+	}
+	return map[string]interface{}{
+		"scheme":   u.Scheme,
+		"host":     u.Host,
+		"hostname": u.Hostname(),
+		"port":     u.Port(),
+		"path":     u.Path,
+		"query":    u.RawQuery,
+		"fragment": u.Fragment,
+		"user":     user,
+	}, nil
+}
+
+func urlJoin(base string, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("Invalid URL: %v", err)
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("Invalid URL: %v", err)
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
@@ -0,0 +1,17 @@
+package data
+
+import (
+	"fmt"
+	"os"
+)
+
+func env(name string) string {
+	return os.Getenv(name)
+}
+
+func requiredEnv(name string) (string, error) {
+	if val, ok := os.LookupEnv(name); ok && val != "" {
+		return val, nil
+	}
+	return "", fmt.Errorf("Required environment variable %v not set", name)
+}
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// How a new value is merged with an existing one. The strategy for a key can be given as a key
+// suffix (e.g. "hosts$replace") or, for objects, with a MergeMarker key inside the object (e.g.
+// "app": {"$merge": "replace", ...}). Without a strategy, objects are deep merged, arrays are
+// appended, and everything else is replaced. Objects in arrays have nothing to merge with, so
+// strategies can't be given inside them.
+const (
+	MergeReplace      = "replace"
+	MergeAppend       = "append"
+	MergePrepend      = "prepend"
+	MergeUniqueAppend = "unique-append"
+	MergeDeep         = "deep-merge"
+	MergeDelete       = "delete"
+)
+
+const MergeMarker = "$merge"
+
+var mergeStrategies = map[string]bool{
+	MergeReplace:      true,
+	MergeAppend:       true,
+	MergePrepend:      true,
+	MergeUniqueAppend: true,
+	MergeDeep:         true,
+	MergeDelete:       true,
+}
+
+// Splits a "key$strategy" key into its key and strategy. If there is no known strategy suffix,
+// the key is returned as is with an empty strategy.
+func SplitMergeKey(key string) (string, string) {
+	if index := strings.LastIndex(key, "$"); index > 0 && mergeStrategies[key[index+1:]] {
+		return key[:index], key[index+1:]
+	}
+	return key, ""
+}
+
+type mergeTracker func(path string, action string, v interface{})
+
+func applyMap(existing map[string]interface{}, newValues map[string]interface{}, path string, track mergeTracker) error {
+	for rawKey, v := range newValues {
+		if rawKey == MergeMarker {
+			continue
+		}
+		k, strategy := SplitMergeKey(rawKey)
+		childPath := JoinPath(path, k)
+		if newMap, ok := v.(map[string]interface{}); ok {
+			if marker, ok := newMap[MergeMarker]; ok {
+				markerStr, _ := marker.(string)
+				if !mergeStrategies[markerStr] {
+					return fmt.Errorf("Unrecognized %v strategy at %v: %v", MergeMarker, childPath, marker)
+				}
+				strategy = markerStr
+			}
+		}
+		if err := applyValue(existing, k, v, strategy, childPath, track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyValue(existing map[string]interface{}, k string, v interface{}, strategy string, path string, track mergeTracker) error {
+	oldValue, exists := existing[k]
+	if strategy == MergeDelete {
+		if exists {
+			delete(existing, k)
+			track(path, SourceActionDelete, nil)
+		}
+		return nil
+	}
+	if newSlice, ok := v.([]interface{}); ok {
+		var err error
+		if v, err = normalizeSlice(newSlice, path); err != nil {
+			return err
+		}
+	}
+	// Maps are always rebuilt so merge directives inside them are applied
+	if newMap, ok := v.(map[string]interface{}); ok {
+		oldMap, oldIsMap := oldValue.(map[string]interface{})
+		if !exists || !oldIsMap || strategy == MergeReplace {
+			action := SourceActionSet
+			if exists {
+				action = SourceActionOverride
+			}
+			oldMap = map[string]interface{}{}
+			existing[k] = oldMap
+			if len(newMap) == 0 {
+				track(path, action, newMap)
+				return nil
+			}
+			return applyMap(oldMap, newMap, path, func(childPath string, _ string, v interface{}) {
+				track(childPath, action, v)
+			})
+		}
+		return applyMap(oldMap, newMap, path, track)
+	}
+	if !exists {
+		existing[k] = v
+		track(path, SourceActionSet, v)
+		return nil
+	}
+	oldSlice, oldIsSlice := oldValue.([]interface{})
+	newSlice, newIsSlice := v.([]interface{})
+	if !oldIsSlice || !newIsSlice {
+		existing[k] = v
+		track(path, SourceActionOverride, v)
+		return nil
+	}
+	// Appending to the old slice in place could change anything else sharing its array
+	switch strategy {
+	case "", MergeAppend:
+		existing[k] = append(append([]interface{}{}, oldSlice...), newSlice...)
+		track(path, SourceActionAppend, v)
+	case MergePrepend:
+		existing[k] = append(append([]interface{}{}, newSlice...), oldSlice...)
+		track(path, SourceActionPrepend, v)
+	case MergeUniqueAppend:
+		merged := append([]interface{}{}, oldSlice...)
+		for _, newItem := range newSlice {
+			if !sliceContains(merged, newItem) {
+				merged = append(merged, newItem)
+			}
+		}
+		existing[k] = merged
+		track(path, SourceActionAppend, v)
+	default:
+		// Replace and deep merge just override non-maps
+		existing[k] = v
+		track(path, SourceActionOverride, v)
+	}
+	return nil
+}
+
+// Maps in arrays are copied as they are since there is nothing to merge them with
+func normalizeSlice(slice []interface{}, path string) ([]interface{}, error) {
+	ret := make([]interface{}, len(slice))
+	for i, item := range slice {
+		itemPath := fmt.Sprintf("%v[%v]", path, i)
+		switch item := item.(type) {
+		case map[string]interface{}:
+			if err := checkNoMergeDirectives(item, itemPath); err != nil {
+				return nil, err
+			}
+			newMap := map[string]interface{}{}
+			if err := applyMap(newMap, item, itemPath, func(string, string, interface{}) {}); err != nil {
+				return nil, err
+			}
+			ret[i] = newMap
+		case []interface{}:
+			newSlice, err := normalizeSlice(item, itemPath)
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = newSlice
+		default:
+			ret[i] = item
+		}
+	}
+	return ret, nil
+}
+
+// A merge directive in a map in an array would have no effect, so it's an error instead of being
+// dropped
+func checkNoMergeDirectives(v interface{}, path string) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for rawKey, child := range v {
+			k, strategy := SplitMergeKey(rawKey)
+			if rawKey == MergeMarker {
+				return fmt.Errorf("Unable to apply %v at %v, objects in arrays have nothing to merge with", MergeMarker, path)
+			} else if strategy != "" {
+				return fmt.Errorf("Unable to apply %v strategy at %v, objects in arrays have nothing to merge with",
+					strategy, JoinPath(path, k))
+			}
+			if err := checkNoMergeDirectives(child, JoinPath(path, k)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if err := checkNoMergeDirectives(item, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sliceContains(slice []interface{}, v interface{}) bool {
+	for _, item := range slice {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
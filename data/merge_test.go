@@ -0,0 +1,126 @@
+package data
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		newVals  string
+		expected string
+		wantErr  bool
+	}{
+		{"set new key", `{}`, `{"a": 1}`, `{"a": 1}`, false},
+		{"replace scalar", `{"a": 1}`, `{"a": 2}`, `{"a": 2}`, false},
+		{"deep merge objects", `{"a": {"b": 1, "c": 2}}`, `{"a": {"c": 3, "d": 4}}`, `{"a": {"b": 1, "c": 3, "d": 4}}`, false},
+		{"append arrays by default", `{"a": [1, 2]}`, `{"a": [3]}`, `{"a": [1, 2, 3]}`, false},
+		{"append suffix", `{"a": [1]}`, `{"a$append": [2]}`, `{"a": [1, 2]}`, false},
+		{"prepend suffix", `{"a": [1]}`, `{"a$prepend": [2]}`, `{"a": [2, 1]}`, false},
+		{"unique append suffix", `{"a": [1, 2]}`, `{"a$unique-append": [2, 3, 3]}`, `{"a": [1, 2, 3]}`, false},
+		{"replace array suffix", `{"a": [1, 2]}`, `{"a$replace": [3]}`, `{"a": [3]}`, false},
+		{"replace object suffix", `{"a": {"b": 1}}`, `{"a$replace": {"c": 2}}`, `{"a": {"c": 2}}`, false},
+		{"replace object marker", `{"a": {"b": 1}}`, `{"a": {"$merge": "replace", "c": 2}}`, `{"a": {"c": 2}}`, false},
+		{"delete suffix", `{"a": 1, "b": 2}`, `{"a$delete": null}`, `{"b": 2}`, false},
+		{"delete missing", `{"b": 2}`, `{"a$delete": null}`, `{"b": 2}`, false},
+		{"nested suffix in new object", `{}`, `{"a": {"b$replace": [1]}}`, `{"a": {"b": [1]}}`, false},
+		{"unknown suffix is part of key", `{}`, `{"a$nope": 1}`, `{"a$nope": 1}`, false},
+		{"unknown marker", `{"a": {}}`, `{"a": {"$merge": "nope"}}`, ``, true},
+		{"objects in array", `{"a": [{"b": 1}]}`, `{"a": [{"c": {"d": [2]}}]}`, `{"a": [{"b": 1}, {"c": {"d": [2]}}]}`, false},
+		{"marker in array", `{}`, `{"a": [{"$merge": "replace", "b": 1}]}`, ``, true},
+		{"delete marker in array", `{}`, `{"a": [{"b": {"$merge": "delete"}}]}`, ``, true},
+		{"suffix in array", `{}`, `{"a": [{"b$replace": [1]}]}`, ``, true},
+		{"delete suffix in array", `{"a": [{"b": 1}]}`, `{"a": [{"b$delete": null}]}`, ``, true},
+		{"suffix in nested array", `{}`, `{"a": [[{"b$replace": 1}]]}`, ``, true},
+		{"suffix deep in array object", `{}`, `{"a": [{"b": {"c": [{"d$append": [1]}]}}]}`, ``, true},
+		{"unknown marker in array", `{}`, `{"a": [{"$merge": "nope"}]}`, ``, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewData()
+			d.Values = jsonMap(t, test.existing)
+			err := d.Merge(jsonMap(t, test.newVals))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if err == nil && !reflect.DeepEqual(d.Values, jsonMap(t, test.expected)) {
+				t.Fatalf("Expected %v, got %v", test.expected, d.Values)
+			}
+		})
+	}
+}
+
+func TestMergeDirectiveInArrayNamesPath(t *testing.T) {
+	err := NewData().Merge(jsonMap(t, `{"a": [{"b": 1}, {"c": {"d$delete": null}}]}`))
+	if err == nil || !strings.Contains(err.Error(), "delete strategy at a[1].c.d") {
+		t.Fatalf("Expected error naming the path, got %v", err)
+	}
+}
+
+func TestMergeDoesNotAliasArrays(t *testing.T) {
+	for _, strategy := range []string{MergeAppend, MergeUniqueAppend, MergePrepend} {
+		t.Run(strategy, func(t *testing.T) {
+			// Spare capacity is what lets an in-place append leak into the other slice
+			shared := make([]interface{}, 1, 10)
+			shared[0] = 1.0
+			d := NewData()
+			d.Values = map[string]interface{}{"a": shared}
+			if err := d.Merge(map[string]interface{}{"a$" + strategy: []interface{}{2.0}}); err != nil {
+				t.Fatal(err)
+			}
+			other := append(shared, 3.0)
+			if merged := d.Values["a"].([]interface{}); len(merged) != 2 || merged[0] == 3.0 || merged[1] == 3.0 {
+				t.Fatalf("Merged value changed to %v", merged)
+			}
+			if other[1] != 3.0 {
+				t.Fatalf("Original array changed to %v", other)
+			}
+		})
+	}
+}
+
+func TestMergeFromTracksSources(t *testing.T) {
+	d := NewData()
+	if err := d.MergeFrom(jsonMap(t, `{"a": {"b": 1}, "c": [1]}`), "one.json", map[string]int{"a.b": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.MergeFromIncluded(jsonMap(t, `{"a": {"b": 2}, "c": [2]}`), "two.json", "main.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path      string
+		locations []string
+		actions   []string
+	}{
+		{"a.b", []string{"one.json:3", "two.json (included from main.json)"}, []string{SourceActionSet, SourceActionOverride}},
+		{"c", []string{"one.json", "two.json (included from main.json)"}, []string{SourceActionSet, SourceActionAppend}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			sources := d.SourcesOf(test.path)
+			if len(sources) != len(test.locations) {
+				t.Fatalf("Expected %v sources, got %v", len(test.locations), len(sources))
+			}
+			for i, source := range sources {
+				if source.Location() != test.locations[i] || source.Action != test.actions[i] {
+					t.Fatalf("Expected %v %v, got %v %v", test.locations[i], test.actions[i], source.Location(), source.Action)
+				}
+			}
+		})
+	}
+}
+
+func jsonMap(t *testing.T, str string) map[string]interface{} {
+	if str == "" {
+		return nil
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(str), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
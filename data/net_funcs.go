@@ -0,0 +1,86 @@
+package data
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// Gives the IP at the given index in the CIDR range. Negative indexes count back from the end.
+func cidrHost(num interface{}, cidr string) (string, error) {
+	n, err := toInt(num)
+	if err != nil {
+		return "", err
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("Invalid CIDR: %v", err)
+	}
+	ones, bits := network.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	index := big.NewInt(int64(n))
+	if n < 0 {
+		index.Add(index, size)
+	}
+	if index.Sign() < 0 || index.Cmp(size) >= 0 {
+		return "", fmt.Errorf("Host number %v out of range for %v", n, cidr)
+	}
+	return addToIP(network.IP, index).String(), nil
+}
+
+func cidrNetmask(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("Invalid CIDR: %v", err)
+	}
+	return net.IP(network.Mask).String(), nil
+}
+
+// Divides the CIDR into smaller networks with the given additional bits and gives the one at num
+func cidrSubnet(newBits interface{}, num interface{}, cidr string) (string, error) {
+	extra, err := toInt(newBits)
+	if err != nil {
+		return "", err
+	}
+	n, err := toInt(num)
+	if err != nil {
+		return "", err
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("Invalid CIDR: %v", err)
+	}
+	ones, bits := network.Mask.Size()
+	newOnes := ones + extra
+	if extra < 0 || newOnes > bits {
+		return "", fmt.Errorf("Cannot extend %v by %v bits", cidr, extra)
+	}
+	if n < 0 || big.NewInt(int64(n)).Cmp(new(big.Int).Lsh(big.NewInt(1), uint(extra))) >= 0 {
+		return "", fmt.Errorf("Subnet number %v out of range for %v bits", n, extra)
+	}
+	offset := new(big.Int).Lsh(big.NewInt(int64(n)), uint(bits-newOnes))
+	subnet := &net.IPNet{IP: addToIP(network.IP, offset), Mask: net.CIDRMask(newOnes, bits)}
+	return subnet.String(), nil
+}
+
+func cidrContains(ip string, cidr string) (bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, fmt.Errorf("Invalid CIDR: %v", err)
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, fmt.Errorf("Invalid IP: %v", ip)
+	}
+	return network.Contains(parsed), nil
+}
+
+func addToIP(ip net.IP, n *big.Int) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	sum := new(big.Int).Add(new(big.Int).SetBytes(ip), n).Bytes()
+	ret := make(net.IP, len(ip))
+	copy(ret[len(ret)-len(sum):], sum)
+	return ret
+}
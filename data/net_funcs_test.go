@@ -0,0 +1,41 @@
+package data
+
+import "testing"
+
+func TestCIDRFuncs(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     func() (interface{}, error)
+		expected interface{}
+		wantErr  bool
+	}{
+		{"host", func() (interface{}, error) { return cidrHost(5, "10.0.0.0/24") }, "10.0.0.5", false},
+		{"host from JSON number", func() (interface{}, error) { return cidrHost(5.0, "10.0.0.0/24") }, "10.0.0.5", false},
+		{"host from end", func() (interface{}, error) { return cidrHost(-2, "10.0.0.0/24") }, "10.0.0.254", false},
+		{"host carries across octets", func() (interface{}, error) { return cidrHost(256, "10.0.0.0/16") }, "10.0.1.0", false},
+		{"host ipv6", func() (interface{}, error) { return cidrHost(1, "fd00::/64") }, "fd00::1", false},
+		{"host out of range", func() (interface{}, error) { return cidrHost(256, "10.0.0.0/24") }, nil, true},
+		{"host negative out of range", func() (interface{}, error) { return cidrHost(-257, "10.0.0.0/24") }, nil, true},
+		{"host invalid CIDR", func() (interface{}, error) { return cidrHost(1, "10.0.0.0") }, nil, true},
+		{"netmask", func() (interface{}, error) { return cidrNetmask("10.0.0.0/20") }, "255.255.240.0", false},
+		{"subnet", func() (interface{}, error) { return cidrSubnet(8, 2, "10.0.0.0/16") }, "10.0.2.0/24", false},
+		{"subnet of unaligned", func() (interface{}, error) { return cidrSubnet(4, 1, "10.1.2.3/16") }, "10.1.16.0/20", false},
+		{"subnet ipv6", func() (interface{}, error) { return cidrSubnet(16, 3, "fd00::/48") }, "fd00:0:0:3::/64", false},
+		{"subnet too many bits", func() (interface{}, error) { return cidrSubnet(9, 0, "10.0.0.0/24") }, nil, true},
+		{"subnet number out of range", func() (interface{}, error) { return cidrSubnet(2, 4, "10.0.0.0/24") }, nil, true},
+		{"contains", func() (interface{}, error) { return cidrContains("10.0.1.5", "10.0.0.0/16") }, true, false},
+		{"does not contain", func() (interface{}, error) { return cidrContains("10.1.0.1", "10.0.0.0/16") }, false, false},
+		{"contains invalid IP", func() (interface{}, error) { return cidrContains("nope", "10.0.0.0/16") }, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := test.call()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if err == nil && actual != test.expected {
+				t.Fatalf("Expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
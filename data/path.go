@@ -0,0 +1,281 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrPathNotFound = errors.New("Not found")
+
+// An error accessing a value at a path like "a.b[0].c"
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (p *PathError) Error() string {
+	return fmt.Sprintf("Config value %v: %v", p.Path, p.Err)
+}
+
+func IsPathNotFound(err error) bool {
+	pathErr, ok := err.(*PathError)
+	return ok && pathErr.Err == ErrPathNotFound
+}
+
+type pathElem struct {
+	key   string
+	index int
+	// Index instead of key
+	isIndex bool
+}
+
+func parsePath(path string) ([]pathElem, error) {
+	elems := []pathElem{}
+	for _, piece := range strings.Split(path, ".") {
+		key := piece
+		indexes := ""
+		if bracket := strings.Index(piece, "["); bracket >= 0 {
+			key, indexes = piece[:bracket], piece[bracket:]
+		}
+		if key == "" && (indexes == "" || len(elems) == 0) {
+			return nil, errors.New("Empty key")
+		}
+		if key != "" {
+			elems = append(elems, pathElem{key: key})
+		}
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if !strings.HasPrefix(indexes, "[") || end < 0 {
+				return nil, fmt.Errorf("Invalid index in %v", piece)
+			}
+			index, err := strconv.Atoi(indexes[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("Invalid index in %v", piece)
+			}
+			elems = append(elems, pathElem{index: index, isIndex: true})
+			indexes = indexes[end+1:]
+		}
+	}
+	return elems, nil
+}
+
+func (d *Data) Get(path string) (interface{}, error) {
+	elems, err := parsePath(path)
+	if err != nil {
+		return nil, &PathError{path, err}
+	}
+	var curr interface{} = d.Values
+	for _, elem := range elems {
+		if elem.isIndex {
+			slice, ok := curr.([]interface{})
+			if !ok {
+				return nil, &PathError{path, fmt.Errorf("Expected array for index %v, got %v", elem.index, jsonTypeName(curr))}
+			}
+			if elem.index >= len(slice) {
+				return nil, &PathError{path, ErrPathNotFound}
+			}
+			curr = slice[elem.index]
+		} else {
+			m, ok := curr.(map[string]interface{})
+			if !ok {
+				return nil, &PathError{path, fmt.Errorf("Expected object for key %v, got %v", elem.key, jsonTypeName(curr))}
+			}
+			if curr, ok = m[elem.key]; !ok {
+				return nil, &PathError{path, ErrPathNotFound}
+			}
+		}
+	}
+	return curr, nil
+}
+
+func (d *Data) Has(path string) bool {
+	_, err := d.Get(path)
+	return err == nil
+}
+
+func (d *Data) GetString(path string) (string, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return "", err
+	}
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	return "", &PathError{path, fmt.Errorf("Expected string, got %v", jsonTypeName(v))}
+}
+
+// Numeric strings are accepted
+func (d *Data) GetFloat(path string) (float64, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, &PathError{path, fmt.Errorf("Invalid number: %v", v)}
+		}
+		return f, nil
+	default:
+		return 0, &PathError{path, fmt.Errorf("Expected number, got %v", jsonTypeName(v))}
+	}
+}
+
+// Numeric strings are accepted
+func (d *Data) GetInt(path string) (int, error) {
+	f, err := d.GetFloat(path)
+	if err != nil {
+		return 0, err
+	}
+	if f != float64(int(f)) {
+		return 0, &PathError{path, fmt.Errorf("Expected integer, got %v", f)}
+	}
+	return int(f), nil
+}
+
+// Strings like "true" and "false" are accepted
+func (d *Data) GetBool(path string) (bool, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return false, err
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, &PathError{path, fmt.Errorf("Invalid boolean: %v", v)}
+		}
+		return b, nil
+	default:
+		return false, &PathError{path, fmt.Errorf("Expected boolean, got %v", jsonTypeName(v))}
+	}
+}
+
+// Strings are parsed like "1m30s" and numbers are seconds
+func (d *Data) GetDuration(path string) (time.Duration, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, &PathError{path, fmt.Errorf("Invalid duration: %v", v)}
+		}
+		return dur, nil
+	default:
+		return 0, &PathError{path, fmt.Errorf("Expected duration, got %v", jsonTypeName(v))}
+	}
+}
+
+func (d *Data) GetStringSlice(path string) ([]string, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	slice, ok := v.([]interface{})
+	if !ok {
+		return nil, &PathError{path, fmt.Errorf("Expected array, got %v", jsonTypeName(v))}
+	}
+	ret := make([]string, len(slice))
+	for i, item := range slice {
+		if ret[i], ok = item.(string); !ok {
+			return nil, &PathError{path, fmt.Errorf("Expected string at index %v, got %v", i, jsonTypeName(item))}
+		}
+	}
+	return ret, nil
+}
+
+// Obtains the object at the path as data sharing the same values. Sources are copied with the path
+// prefix removed.
+func (d *Data) Sub(path string) (*Data, error) {
+	v, err := d.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &PathError{path, fmt.Errorf("Expected object, got %v", jsonTypeName(v))}
+	}
+	sub := &Data{Values: m, Resources: d.Resources, Prompter: d.Prompter, funcs: d.funcs}
+	for _, source := range d.SourcesOf(path) {
+		if source.Path != path {
+			subSource := *source
+			subSource.Path = strings.TrimPrefix(source.Path, path+".")
+			sub.Sources = append(sub.Sources, &subSource)
+		}
+	}
+	return sub, nil
+}
+
+// Unmarshals only the value at the path into v the same way as UnmarshalJSON
+func (d *Data) UnmarshalKey(path string, v interface{}) error {
+	val, err := d.Get(path)
+	if err != nil {
+		return err
+	}
+	byts, err := json.Marshal(val)
+	if err != nil {
+		return &PathError{path, fmt.Errorf("Unable to marshal to JSON: %v", err)}
+	}
+	if err = json.Unmarshal(byts, v); err != nil {
+		return &PathError{path, fmt.Errorf("Unable to unmarshal: %v", err)}
+	}
+	return nil
+}
+
+// Sets the value at the path, creating objects along the way as needed. Indexes must already
+// exist. This replaces any existing value without merging.
+func (d *Data) Set(path string, value interface{}) error {
+	elems, err := parsePath(path)
+	if err != nil {
+		return &PathError{path, err}
+	}
+	var curr interface{} = d.Values
+	for i, elem := range elems {
+		last := i == len(elems)-1
+		if elem.isIndex {
+			slice, ok := curr.([]interface{})
+			if !ok {
+				return &PathError{path, fmt.Errorf("Expected array for index %v, got %v", elem.index, jsonTypeName(curr))}
+			}
+			if elem.index >= len(slice) {
+				return &PathError{path, fmt.Errorf("Index %v out of range", elem.index)}
+			}
+			if last {
+				slice[elem.index] = value
+				return nil
+			}
+			if slice[elem.index] == nil && !elems[i+1].isIndex {
+				slice[elem.index] = map[string]interface{}{}
+			}
+			curr = slice[elem.index]
+		} else {
+			m, ok := curr.(map[string]interface{})
+			if !ok {
+				return &PathError{path, fmt.Errorf("Expected object for key %v, got %v", elem.key, jsonTypeName(curr))}
+			}
+			if last {
+				m[elem.key] = value
+				return nil
+			}
+			if _, ok := m[elem.key]; !ok && !elems[i+1].isIndex {
+				m[elem.key] = map[string]interface{}{}
+			}
+			curr = m[elem.key]
+		}
+	}
+	return nil
+}
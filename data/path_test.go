@@ -0,0 +1,142 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const pathTestValues = `{
+	"a": {"b": [{"c": "x"}, {"c": "y", "d": [1, 2]}]},
+	"str": "s", "num": 1.5, "int": 3, "numStr": "42", "bool": true, "boolStr": "false",
+	"dur": "1m30s", "durNum": 2, "strs": ["p", "q"], "mixed": ["p", 1]
+}`
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected interface{}
+		notFound bool
+		wantErr  bool
+	}{
+		{"str", "s", false, false},
+		{"a.b[0].c", "x", false, false},
+		{"a.b[1].d[1]", 2.0, false, false},
+		{"a.nope", nil, true, true},
+		{"a.b[2]", nil, true, true},
+		{"str.nope", nil, false, true},
+		{"a.b.c", nil, false, true},
+		{"", nil, false, true},
+		{"a..b", nil, false, true},
+		{"a.b[x]", nil, false, true},
+		{"a.b[-1]", nil, false, true},
+		{"a.b[0", nil, false, true},
+	}
+	d := NewData()
+	d.Values = jsonMap(t, pathTestValues)
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			actual, err := d.Get(test.path)
+			if (err != nil) != test.wantErr || IsPathNotFound(err) != test.notFound {
+				t.Fatalf("Expected error %v (not found %v), got %v", test.wantErr, test.notFound, err)
+			}
+			if err == nil && !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("Expected %v, got %v", test.expected, actual)
+			}
+			if d.Has(test.path) != !test.wantErr {
+				t.Fatalf("Expected has %v", !test.wantErr)
+			}
+		})
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	tests := []struct {
+		name     string
+		get      func(d *Data) (interface{}, error)
+		expected interface{}
+		wantErr  bool
+	}{
+		{"string", func(d *Data) (interface{}, error) { return d.GetString("str") }, "s", false},
+		{"string of number", func(d *Data) (interface{}, error) { return d.GetString("num") }, nil, true},
+		{"float", func(d *Data) (interface{}, error) { return d.GetFloat("num") }, 1.5, false},
+		{"float of string", func(d *Data) (interface{}, error) { return d.GetFloat("numStr") }, 42.0, false},
+		{"float of invalid string", func(d *Data) (interface{}, error) { return d.GetFloat("str") }, nil, true},
+		{"int", func(d *Data) (interface{}, error) { return d.GetInt("int") }, 3, false},
+		{"int of string", func(d *Data) (interface{}, error) { return d.GetInt("numStr") }, 42, false},
+		{"int of fraction", func(d *Data) (interface{}, error) { return d.GetInt("num") }, nil, true},
+		{"bool", func(d *Data) (interface{}, error) { return d.GetBool("bool") }, true, false},
+		{"bool of string", func(d *Data) (interface{}, error) { return d.GetBool("boolStr") }, false, false},
+		{"bool of number", func(d *Data) (interface{}, error) { return d.GetBool("num") }, nil, true},
+		{"duration", func(d *Data) (interface{}, error) { return d.GetDuration("dur") }, 90 * time.Second, false},
+		{"duration of seconds", func(d *Data) (interface{}, error) { return d.GetDuration("durNum") }, 2 * time.Second, false},
+		{"duration of invalid", func(d *Data) (interface{}, error) { return d.GetDuration("str") }, nil, true},
+		{"string slice", func(d *Data) (interface{}, error) { return d.GetStringSlice("strs") }, []string{"p", "q"}, false},
+		{"string slice of mixed", func(d *Data) (interface{}, error) { return d.GetStringSlice("mixed") }, nil, true},
+		{"missing", func(d *Data) (interface{}, error) { return d.GetString("nope") }, nil, true},
+	}
+	d := NewData()
+	d.Values = jsonMap(t, pathTestValues)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := test.get(d)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if err == nil && !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("Expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		value    interface{}
+		expected string
+		wantErr  bool
+	}{
+		{"new key", "b", 1.0, `{"a": {"b": [1]}, "b": 1}`, false},
+		{"creates objects", "x.y.z", "v", `{"a": {"b": [1]}, "x": {"y": {"z": "v"}}}`, false},
+		{"replaces", "a", "v", `{"a": "v"}`, false},
+		{"index", "a.b[0]", 2.0, `{"a": {"b": [2]}}`, false},
+		{"index out of range", "a.b[1]", 2.0, ``, true},
+		{"index of non-array", "a[0]", 2.0, ``, true},
+		{"through non-object", "a.b.c", 2.0, ``, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewData()
+			d.Values = jsonMap(t, `{"a": {"b": [1]}}`)
+			err := d.Set(test.path, test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if err == nil && !reflect.DeepEqual(d.Values, jsonMap(t, test.expected)) {
+				t.Fatalf("Expected %v, got %v", test.expected, d.Values)
+			}
+		})
+	}
+}
+
+func TestSub(t *testing.T) {
+	d := NewData()
+	if err := d.MergeFrom(jsonMap(t, `{"a": {"b": {"c": 1}}, "d": 2}`), "config.json", map[string]int{"a.b.c": 3}); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := d.Sub("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := sub.GetInt("b.c"); err != nil || c != 1 {
+		t.Fatalf("Expected 1, got %v (%v)", c, err)
+	}
+	if sources := sub.SourcesOf("b.c"); len(sources) != 1 || sources[0].Location() != "config.json:3" {
+		t.Fatalf("Expected source config.json:3, got %v", sources)
+	}
+	if _, err := d.Sub("d"); err == nil {
+		t.Fatal("Expected error for non-object")
+	}
+}
@@ -1,18 +1,142 @@
 package data
 
 import (
+	"bufio"
 	"fmt"
 	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"regexp"
 	"strings"
 	"syscall"
 )
 
-func hiddenPrompt(str string) (string, error) {
-	fmt.Print(str)
-	byts, err := terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+// Env vars with this prefix followed by the prompt key (upper case, non-alphanumerics as
+// underscores) answer prompts, e.g. SYST_ANSWER_DB_PASSWORD for "DB password:"
+const PromptEnvPrefix = "SYST_ANSWER_"
+
+type PromptRequest struct {
+	Text    string   `json:"text"`
+	Hidden  bool     `json:"hidden"`
+	Default string   `json:"default"`
+	Choices []string `json:"choices"`
+}
+
+// The key used to look up answers: the text trimmed of whitespace and trailing colons
+func (p *PromptRequest) Key() string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(p.Text), ":"))
+}
+
+var nonEnvChars = regexp.MustCompile("[^A-Z0-9]+")
+
+func (p *PromptRequest) EnvVar() string {
+	return PromptEnvPrefix + strings.Trim(nonEnvChars.ReplaceAllString(strings.ToUpper(p.Key()), "_"), "_")
+}
+
+func (p *PromptRequest) validate(answer string) (string, error) {
+	if answer == "" {
+		answer = p.Default
+	}
+	if len(p.Choices) == 0 {
+		return answer, nil
+	}
+	for _, choice := range p.Choices {
+		if answer == choice {
+			return answer, nil
+		}
+	}
+	return "", fmt.Errorf("Answer %q for prompt %q not one of %v", answer, p.Key(), strings.Join(p.Choices, ", "))
+}
+
+// Answers prompts from, in order: the answers map, env vars, the relay, the terminal, and the
+// default. It fails if none of them apply.
+type Prompter struct {
+	// Keyed by PromptRequest.Key
+	Answers map[string]string
+	// If set, prompts are sent here instead of the terminal (e.g. to the controller from remote)
+	Relay func(*PromptRequest) (string, error)
+	// Called with every answer to a hidden prompt
+	OnHiddenAnswer func(string)
+	// Each prompt is only asked once, even if templates are applied multiple times
+	answered map[string]string
+}
+
+func NewPrompter(answers map[string]string) *Prompter {
+	return &Prompter{Answers: answers}
+}
+
+func (p *Prompter) Prompt(req *PromptRequest) (string, error) {
+	if answer, ok := p.answered[req.Key()]; ok {
+		return answer, nil
+	}
+	answer, err := p.answer(req)
 	if err != nil {
-		return "", fmt.Errorf("Failed obtaining hidden prompt: %v", err)
+		return "", err
+	}
+	if req.Hidden && answer != "" && p.OnHiddenAnswer != nil {
+		p.OnHiddenAnswer(answer)
+	}
+	if p.answered == nil {
+		p.answered = map[string]string{}
+	}
+	p.answered[req.Key()] = answer
+	return answer, nil
+}
+
+func (p *Prompter) answer(req *PromptRequest) (string, error) {
+	if answer, ok := p.Answers[req.Key()]; ok {
+		return req.validate(answer)
+	}
+	if answer, ok := os.LookupEnv(req.EnvVar()); ok {
+		return req.validate(answer)
+	}
+	if p.Relay != nil {
+		return p.Relay(req)
+	}
+	if terminal.IsTerminal(int(syscall.Stdin)) {
+		return terminalPrompt(req)
+	}
+	if req.Default != "" {
+		return req.Default, nil
+	}
+	return "", fmt.Errorf("No answer for prompt %q: not a terminal, not in answers, and %v not set",
+		req.Key(), req.EnvVar())
+}
+
+// Shared by every prompt since a reader may buffer past the line it returns, which a new reader for
+// the next prompt would never see
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func terminalPrompt(req *PromptRequest) (string, error) {
+	text := req.Text
+	if len(req.Choices) > 0 {
+		text = fmt.Sprintf("%v [%v] ", strings.TrimRight(text, " "), strings.Join(req.Choices, "/"))
+	}
+	if req.Default != "" && !req.Hidden {
+		text = fmt.Sprintf("%v(default %v) ", text, req.Default)
+	}
+	// Ask again for invalid choices
+	for {
+		fmt.Print(text)
+		var answer string
+		// Lines typed ahead were already echoed, so there is nothing left to hide
+		if req.Hidden && stdinReader.Buffered() == 0 {
+			byts, err := terminal.ReadPassword(int(syscall.Stdin))
+			fmt.Println()
+			if err != nil {
+				return "", fmt.Errorf("Failed obtaining hidden prompt: %v", err)
+			}
+			answer = string(byts)
+		} else {
+			line, err := stdinReader.ReadString('\n')
+			if err != nil {
+				return "", fmt.Errorf("Failed obtaining prompt: %v", err)
+			}
+			answer = line
+		}
+		answer, err := req.validate(strings.TrimSpace(answer))
+		if err == nil {
+			return answer, nil
+		}
+		fmt.Println(err)
 	}
-	return strings.TrimSpace(string(byts)), nil
 }
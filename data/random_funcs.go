@@ -0,0 +1,66 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const passwordChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Generates a password of the given length. With a non-empty seed the same password is generated
+// every time (so configs re-rendered on every run stay stable), otherwise it is truly random. A
+// seeded password is only as secret as its seed: anyone who knows or guesses the seed can derive
+// it, so seeds must not be used for real secrets. Those should be unseeded and stored, or prompted.
+func randomPassword(length interface{}, seed ...string) (string, error) {
+	n, err := toInt(length)
+	if err != nil {
+		return "", err
+	}
+	var source io.Reader = rand.Reader
+	if len(seed) > 0 && seed[0] != "" {
+		source = &seededReader{key: []byte(seed[0])}
+	}
+	ret := make([]byte, n)
+	buf := make([]byte, 1)
+	// Reject bytes that would bias the result
+	max := byte(256 - 256%len(passwordChars))
+	for i := 0; i < n; {
+		if _, err := io.ReadFull(source, buf); err != nil {
+			return "", fmt.Errorf("Unable to generate password: %v", err)
+		}
+		if buf[0] < max {
+			ret[i] = passwordChars[int(buf[0])%len(passwordChars)]
+			i++
+		}
+	}
+	return string(ret), nil
+}
+
+// Deterministic byte stream of HMAC-SHA256(key, counter) blocks
+type seededReader struct {
+	key     []byte
+	counter uint64
+	buf     []byte
+}
+
+func (s *seededReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.buf) == 0 {
+			mac := hmac.New(sha256.New, s.key)
+			counter := make([]byte, 8)
+			binary.BigEndian.PutUint64(counter, s.counter)
+			mac.Write(counter)
+			s.buf = mac.Sum(nil)
+			s.counter++
+		}
+		copied := copy(p[n:], s.buf)
+		s.buf = s.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A subset of JSON Schema used to validate the merged config
+type Schema struct {
+	Description string `json:"description"`
+	// Either a single type name or an array of them
+	Type                 interface{}        `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Pattern              string             `json:"pattern"`
+}
+
+func SchemaFromMap(m map[string]interface{}) (*Schema, error) {
+	schema := &Schema{}
+	if err := UnmarshalJSONMap(m, schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+type ValidationError struct {
+	// Dotted path with array indexes in brackets, empty for the root
+	Path    string
+	Message string
+	// The file and line that last set the value, if known
+	Source string
+}
+
+func (v *ValidationError) Error() string {
+	path := v.Path
+	if path == "" {
+		path = "<root>"
+	}
+	if v.Source != "" {
+		return fmt.Sprintf("%v: %v (from %v)", path, v.Message, v.Source)
+	}
+	return fmt.Sprintf("%v: %v", path, v.Message)
+}
+
+// Validates the values against all schemas and returns every error found, sorted by path
+func (d *Data) Validate(schemas ...*Schema) []*ValidationError {
+	errs := []*ValidationError{}
+	for _, schema := range schemas {
+		schema.validate(d.Values, "", &errs)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return pathLess(errs[i].Path, errs[j].Path) })
+	for _, err := range errs {
+		err.Source = d.lastSourceLocation(err.Path)
+	}
+	return errs
+}
+
+// Orders by key, then by index numerically so "a[2]" is before "a[10]"
+func pathLess(a string, b string) bool {
+	// The root is unparseable and sorts first as empty
+	aElems, _ := parsePath(a)
+	bElems, _ := parsePath(b)
+	for i := 0; i < len(aElems) && i < len(bElems); i++ {
+		aElem, bElem := aElems[i], bElems[i]
+		if aElem.isIndex != bElem.isIndex {
+			return aElem.isIndex
+		} else if aElem.isIndex && aElem.index != bElem.index {
+			return aElem.index < bElem.index
+		} else if !aElem.isIndex && aElem.key != bElem.key {
+			return aElem.key < bElem.key
+		}
+	}
+	return len(aElems) < len(bElems)
+}
+
+func (d *Data) lastSourceLocation(path string) string {
+	// Sources are not tracked inside arrays, so use the array itself
+	if index := strings.Index(path, "["); index >= 0 {
+		path = path[:index]
+	}
+	// Missing values have no source, so walk up until something does (but not to the root)
+	for path != "" {
+		if sources := d.SourcesOf(path); len(sources) > 0 {
+			source := sources[len(sources)-1]
+			if source.File == "" {
+				return ""
+			}
+			return source.Location()
+		}
+		if index := strings.LastIndex(path, "."); index >= 0 {
+			path = path[:index]
+		} else {
+			path = ""
+		}
+	}
+	return ""
+}
+
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		ret := []string{}
+		for _, v := range t {
+			if str, ok := v.(string); ok {
+				ret = append(ret, str)
+			}
+		}
+		return ret
+	default:
+		return nil
+	}
+}
+
+func schemaTypeMatches(typ string, v interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	default:
+		return false
+	}
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *[]*ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if types := s.types(); len(types) > 0 {
+		matched := false
+		for _, typ := range types {
+			if schemaTypeMatches(typ, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("Expected type %v, got %v", strings.Join(types, " or "), jsonTypeName(v))
+			return
+		}
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(allowed, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("Value %v not one of %v", v, s.Enum)
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		// Characters, not bytes
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("Length must be at least %v", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("Length must be at most %v", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			if re, err := regexp.Compile(s.Pattern); err != nil {
+				fail("Invalid schema pattern %v: %v", s.Pattern, err)
+			} else if !re.MatchString(v) {
+				fail("Value does not match pattern %v", s.Pattern)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("Value must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("Value must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("Must have at least %v items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("Must have at most %v items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, path+"["+strconv.Itoa(i)+"]", errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, required := range s.Required {
+			if _, ok := v[required]; !ok {
+				*errs = append(*errs, &ValidationError{Path: JoinPath(path, required), Message: "Required"})
+			}
+		}
+		for key, child := range v {
+			if prop, ok := s.Properties[key]; ok {
+				prop.validate(child, JoinPath(path, key), errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, &ValidationError{Path: JoinPath(path, key), Message: "Unknown property"})
+			}
+		}
+	}
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
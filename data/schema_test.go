@@ -0,0 +1,88 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		values string
+		// Each error as "path: message"
+		expected []string
+	}{
+		{"valid", `{"type": "object", "properties": {"a": {"type": "string"}}}`, `{"a": "x"}`, nil},
+		{"wrong type", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`,
+			[]string{"a: Expected type string, got number"}},
+		{"one of types", `{"properties": {"a": {"type": ["string", "null"]}}}`, `{"a": null}`, nil},
+		{"integer", `{"properties": {"a": {"type": "integer"}, "b": {"type": "integer"}}}`, `{"a": 1, "b": 1.5}`,
+			[]string{"b: Expected type integer, got number"}},
+		{"required", `{"required": ["a", "b"]}`, `{"a": 1}`, []string{"b: Required"}},
+		{"no additional properties", `{"additionalProperties": false, "properties": {"a": {}}}`, `{"a": 1, "b": 2}`,
+			[]string{"b: Unknown property"}},
+		{"enum", `{"properties": {"a": {"enum": ["x", "y"]}}}`, `{"a": "z"}`, []string{"a: Value z not one of [x y]"}},
+		{"minimum and maximum", `{"properties": {"a": {"minimum": 1}, "b": {"maximum": 1}}}`, `{"a": 0, "b": 2}`,
+			[]string{"a: Value must be at least 1", "b: Value must be at most 1"}},
+		{"min length counts characters", `{"properties": {"a": {"minLength": 3}}}`, `{"a": "héé"}`, nil},
+		{"max length counts characters", `{"properties": {"a": {"maxLength": 3}}}`, `{"a": "日本語"}`, nil},
+		{"too short", `{"properties": {"a": {"minLength": 3}}}`, `{"a": "日本"}`, []string{"a: Length must be at least 3"}},
+		{"pattern", `{"properties": {"a": {"pattern": "^[a-z]+$"}}}`, `{"a": "A"}`,
+			[]string{"a: Value does not match pattern ^[a-z]+$"}},
+		{"items", `{"properties": {"a": {"minItems": 1, "items": {"type": "string"}}}}`, `{"a": ["x", 1]}`,
+			[]string{"a[1]: Expected type string, got number"}},
+		{"too few items", `{"properties": {"a": {"minItems": 2}}}`, `{"a": [1]}`, []string{"a: Must have at least 2 items"}},
+		{"sorted by index numerically", `{"properties": {"a": {"items": {"type": "string"}}}}`,
+			`{"a": [1, "x", 2, "x", "x", "x", "x", "x", "x", "x", 3]}`,
+			[]string{"a[0]: Expected type string, got number", "a[2]: Expected type string, got number",
+				"a[10]: Expected type string, got number"}},
+		{"nested paths sorted", `{"properties": {"b": {"type": "string"}, "a": {"properties": {"c": {"type": "string"}}}}}`,
+			`{"a": {"c": 1}, "b": 1}`, []string{"a.c: Expected type string, got number", "b: Expected type string, got number"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := SchemaFromMap(jsonMap(t, test.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewData()
+			d.Values = jsonMap(t, test.values)
+			actual := []string{}
+			for _, err := range d.Validate(schema) {
+				actual = append(actual, err.Error())
+			}
+			if len(actual) != len(test.expected) || (len(actual) > 0 && !reflect.DeepEqual(actual, test.expected)) {
+				t.Fatalf("Expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestValidateReportsSource(t *testing.T) {
+	d := NewData()
+	if err := d.MergeFrom(jsonMap(t, `{"a": {"b": 1}}`), "config.json", map[string]int{"a.b": 4}); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := SchemaFromMap(jsonMap(t, `{"properties": {"a": {"required": ["c"], "properties": {"b": {"type": "string"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		source string
+	}{
+		{"a.b", "config.json:4"},
+		// Missing values use what last set their parent
+		{"a.c", "config.json:4"},
+	}
+	errs := d.Validate(schema)
+	if len(errs) != len(tests) {
+		t.Fatalf("Expected %v errors, got %v", len(tests), len(errs))
+	}
+	for i, test := range tests {
+		if errs[i].Path != test.path || errs[i].Source != test.source {
+			t.Fatalf("Expected %v from %v, got %v from %v", test.path, test.source, errs[i].Path, errs[i].Source)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package data
+
+import "strings"
+
+// Keys whose string values are always treated as secret. More can be added in config via a
+// top-level "secretKeys" array.
+var DefaultSecretKeys = []string{"pass", "password", "secret", "token", "apiKey"}
+
+// Obtains all string values in the data whose key is a secret key, no matter how deeply nested
+func (d *Data) SecretValues() []string {
+	keys := map[string]bool{}
+	for _, key := range DefaultSecretKeys {
+		keys[strings.ToLower(key)] = true
+	}
+	if extra, ok := d.Values["secretKeys"].([]interface{}); ok {
+		for _, key := range extra {
+			if key, ok := key.(string); ok {
+				keys[strings.ToLower(key)] = true
+			}
+		}
+	}
+	return collectSecretValues(d.Values, keys, false, nil)
+}
+
+func collectSecretValues(v interface{}, keys map[string]bool, secret bool, found []string) []string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			found = collectSecretValues(child, keys, secret || keys[strings.ToLower(key)], found)
+		}
+	case []interface{}:
+		for _, child := range v {
+			found = collectSecretValues(child, keys, secret, found)
+		}
+	case string:
+		if secret {
+			found = append(found, v)
+		}
+	}
+	return found
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	SourceActionSet      = "set"
+	SourceActionOverride = "override"
+	SourceActionAppend   = "append"
+	SourceActionPrepend  = "prepend"
+	SourceActionDelete   = "delete"
+)
+
+// Where a merged value came from
+type ValueSource struct {
+	// Dotted path of the key
+	Path string
+	// Empty if not merged from a file
+	File string
+	// The file that included File, if any
+	IncludedFrom string
+	// Zero if unknown
+	Line   int
+	Action string
+	Value  interface{}
+}
+
+func (v *ValueSource) Location() string {
+	loc := v.File
+	if loc == "" {
+		loc = "<unknown>"
+	}
+	if v.Line > 0 {
+		loc = fmt.Sprintf("%v:%v", loc, v.Line)
+	}
+	if v.IncludedFrom != "" {
+		loc += " (included from " + v.IncludedFrom + ")"
+	}
+	return loc
+}
+
+func JoinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// Obtains the sources for the path or any path beneath it in the order they were applied
+func (d *Data) SourcesOf(path string) []*ValueSource {
+	ret := []*ValueSource{}
+	for _, source := range d.Sources {
+		if path == "" || source.Path == path || strings.HasPrefix(source.Path, path+".") {
+			ret = append(ret, source)
+		}
+	}
+	return ret
+}
+
+// Obtains the dotted paths of all leaf values that differ between the two, sorted
+func DiffPaths(a map[string]interface{}, b map[string]interface{}) []string {
+	paths := []string{}
+	diffPaths(a, b, "", &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+func diffPaths(a interface{}, b interface{}, path string, paths *[]string) {
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+	if !aIsMap || !bIsMap {
+		if !reflect.DeepEqual(a, b) {
+			*paths = append(*paths, path)
+		}
+		return
+	}
+	for key, aVal := range aMap {
+		diffPaths(aVal, bMap[key], JoinPath(path, key), paths)
+	}
+	for key, bVal := range bMap {
+		if _, ok := aMap[key]; !ok {
+			diffPaths(nil, bVal, JoinPath(path, key), paths)
+		}
+	}
+}
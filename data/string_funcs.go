@@ -0,0 +1,183 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Functions here take the piped value last so they can be used as "{{ .prev.x | trimPrefix "a" }}"
+
+func trimPrefix(prefix string, str string) string {
+	return strings.TrimPrefix(str, prefix)
+}
+
+func trimSuffix(suffix string, str string) string {
+	return strings.TrimSuffix(str, suffix)
+}
+
+func replace(old string, new string, str string) string {
+	return strings.Replace(str, old, new, -1)
+}
+
+// Upper cases the first letter of each space-separated word. Unlike strings.Title, letters after
+// punctuation (e.g. "it's" or "o'neil") are left alone.
+func title(str string) string {
+	prevSpace := true
+	return strings.Map(func(r rune) rune {
+		wordStart := prevSpace
+		prevSpace = unicode.IsSpace(r)
+		if wordStart {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, str)
+}
+
+func split(sep string, str string) []string {
+	return strings.Split(str, sep)
+}
+
+func join(sep string, v interface{}) (string, error) {
+	strs, err := toStrings(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strs, sep), nil
+}
+
+func contains(substr string, str string) bool {
+	return strings.Contains(str, substr)
+}
+
+func hasPrefix(prefix string, str string) bool {
+	return strings.HasPrefix(str, prefix)
+}
+
+func hasSuffix(suffix string, str string) bool {
+	return strings.HasSuffix(str, suffix)
+}
+
+func repeat(count interface{}, str string) (string, error) {
+	n, err := toInt(count)
+	if err != nil {
+		return "", err
+	}
+	return strings.Repeat(str, n), nil
+}
+
+func indent(spaces interface{}, str string) (string, error) {
+	n, err := toInt(spaces)
+	if err != nil {
+		return "", err
+	}
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(str, "\n", "\n"+pad, -1), nil
+}
+
+func quote(v interface{}) string {
+	return strconv.Quote(toString(v))
+}
+
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return val.Len() == 0
+	case reflect.Bool:
+		return !val.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return val.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return val.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return val.IsNil()
+	}
+	return false
+}
+
+func defaultVal(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || empty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func coalesce(v ...interface{}) interface{} {
+	for _, val := range v {
+		if !empty(val) {
+			return val
+		}
+	}
+	return nil
+}
+
+func ternary(ifTrue interface{}, ifFalse interface{}, cond bool) interface{} {
+	if cond {
+		return ifTrue
+	}
+	return ifFalse
+}
+
+func required(msg string, v ...interface{}) (interface{}, error) {
+	if len(v) == 0 || empty(v[0]) {
+		return nil, fmt.Errorf("Required value missing: %v", msg)
+	}
+	return v[0], nil
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func toStrings(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		ret := make([]string, len(v))
+		for i, item := range v {
+			ret[i] = toString(item)
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("Expected list, got %T", v)
+	}
+}
+
+// JSON numbers are float64 but template literals are int, so accept both
+func toInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("Expected integer, got %v", v)
+		}
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("Expected integer, got %T", v)
+	}
+}
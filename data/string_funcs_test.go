@@ -0,0 +1,22 @@
+package data
+
+import "testing"
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected string
+	}{
+		{"hello world", "Hello World"},
+		{"it's o'neil", "It's O'neil"},
+		{"  spaced\tout\nlines", "  Spaced\tOut\nLines"},
+		{"élan über", "Élan Über"},
+		{"already Title", "Already Title"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if actual := title(test.str); actual != test.expected {
+			t.Fatalf("Expected %q, got %q", test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package facts
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/util"
+	"os"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitSysV    = "sysvinit"
+	InitUnknown = "unknown"
+)
+
+type Facts struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	// The "ID" from os-release (e.g. "ubuntu"), empty if unknown
+	Distro string `json:"distro"`
+	// The "ID_LIKE" from os-release (e.g. ["debian"])
+	DistroLike    []string `json:"distroLike"`
+	DistroVersion string   `json:"distroVersion"`
+	KernelVersion string   `json:"kernelVersion"`
+	CPUCount      int      `json:"cpuCount"`
+	CPUModel      string   `json:"cpuModel"`
+	MemoryBytes   uint64   `json:"memoryBytes"`
+	Disks         []*Disk  `json:"disks"`
+	IPAddresses   []string `json:"ipAddresses"`
+	InitSystem    string   `json:"initSystem"`
+}
+
+type Disk struct {
+	Device     string `json:"device"`
+	MountPoint string `json:"mountPoint"`
+	FSType     string `json:"fsType"`
+	TotalBytes uint64 `json:"totalBytes"`
+	FreeBytes  uint64 `json:"freeBytes"`
+}
+
+// Gathers facts about this host. Unavailable facts are left empty instead of erroring.
+func Gather() (*Facts, error) {
+	f := &Facts{
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUCount:   runtime.NumCPU(),
+		InitSystem: InitUnknown,
+	}
+	var err error
+	if f.Hostname, err = os.Hostname(); err != nil {
+		return nil, fmt.Errorf("Unable to get hostname: %v", err)
+	}
+	if f.IPAddresses, err = util.LocalIPAddresses(); err != nil {
+		return nil, fmt.Errorf("Unable to get IP addresses: %v", err)
+	}
+	if err = gatherPlatform(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Obtains the facts cached in the data, gathering and caching them if not there
+func Get(d *data.Data) (*Facts, error) {
+	f := &Facts{}
+	if d.Facts != nil {
+		if err := data.UnmarshalJSONMap(d.Facts, f); err != nil {
+			return nil, fmt.Errorf("Invalid cached facts: %v", err)
+		}
+		return f, nil
+	}
+	f, err := Gather()
+	if err != nil {
+		return nil, err
+	}
+	m, err := f.Map()
+	if err != nil {
+		return nil, err
+	}
+	d.Facts = m
+	return f, nil
+}
+
+// The "facts" template function, which gives the facts of the host the template is applied on,
+// gathering them on first use
+func Funcs(d *data.Data) template.FuncMap {
+	return template.FuncMap{
+		"facts": func() (map[string]interface{}, error) {
+			if _, err := Get(d); err != nil {
+				return nil, err
+			}
+			return d.Facts, nil
+		},
+	}
+}
+
+// Converts to the JSON-like form stored in data and given to templates
+func (f *Facts) Map() (map[string]interface{}, error) {
+	byts, err := json.Marshal(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to marshal facts: %v", err)
+	}
+	m := map[string]interface{}{}
+	if err = json.Unmarshal(byts, &m); err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal facts: %v", err)
+	}
+	return m, nil
+}
+
+// True if the distro or any distro it is like is one of the names
+func (f *Facts) DistroIs(names ...string) bool {
+	for _, name := range names {
+		if strings.EqualFold(f.Distro, name) {
+			return true
+		}
+		for _, like := range f.DistroLike {
+			if strings.EqualFold(like, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *Facts) InitSystemIs(names ...string) bool {
+	for _, name := range names {
+		if f.InitSystem == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package facts
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func gatherPlatform(f *Facts) error {
+	if release, err := readKeyValues("/etc/os-release", "="); err == nil {
+		f.Distro = release["ID"]
+		if like := release["ID_LIKE"]; like != "" {
+			f.DistroLike = strings.Fields(like)
+		}
+		f.DistroVersion = release["VERSION_ID"]
+	}
+	if byts, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		f.KernelVersion = strings.TrimSpace(string(byts))
+	}
+	if cpuInfo, err := readKeyValues("/proc/cpuinfo", ":"); err == nil {
+		f.CPUModel = cpuInfo["model name"]
+	}
+	if memInfo, err := readKeyValues("/proc/meminfo", ":"); err == nil {
+		// In the form "1234 kB"
+		if fields := strings.Fields(memInfo["MemTotal"]); len(fields) > 0 {
+			if kb, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+				f.MemoryBytes = kb * 1024
+			}
+		}
+	}
+	f.Disks = disks()
+	f.InitSystem = initSystem()
+	return nil
+}
+
+// Only the first occurrence of each key is kept and values are unquoted
+func readKeyValues(path string, sep string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ret := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pieces := strings.SplitN(scanner.Text(), sep, 2)
+		if len(pieces) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(pieces[0])
+		if _, ok := ret[key]; !ok {
+			ret[key] = strings.Trim(strings.TrimSpace(pieces[1]), "\"'")
+		}
+	}
+	return ret, scanner.Err()
+}
+
+func disks() []*Disk {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	ret := []*Disk{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Only real block devices
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		disk := &Disk{Device: fields[0], MountPoint: fields[1], FSType: fields[2]}
+		stat := &syscall.Statfs_t{}
+		if err := syscall.Statfs(disk.MountPoint, stat); err == nil {
+			disk.TotalBytes = stat.Blocks * uint64(stat.Bsize)
+			disk.FreeBytes = stat.Bavail * uint64(stat.Bsize)
+		}
+		ret = append(ret, disk)
+	}
+	return ret
+}
+
+func initSystem() string {
+	if exists("/run/systemd/system") {
+		return InitSystemd
+	}
+	if exists("/sbin/openrc") || exists("/run/openrc") {
+		return InitOpenRC
+	}
+	if exists("/etc/init.d") {
+		return InitSysV
+	}
+	return InitUnknown
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
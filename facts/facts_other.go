@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package facts
+
+func gatherPlatform(f *Facts) error {
+	// Only the runtime facts are available off of Linux
+	return nil
+}
@@ -0,0 +1,49 @@
+package facts
+
+import (
+	"github.com/cretz/systrument/data"
+	"testing"
+)
+
+func TestFactsTemplateFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		tmpl     string
+		expected string
+	}{
+		{"field", `{{ (facts).distro }}`, "ubuntu"},
+		{"config key of the same name", `{{ .prev.facts }} {{ (facts).os }}`, "mine linux"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := data.NewData()
+			d.Values["facts"] = "mine"
+			d.Facts = map[string]interface{}{"distro": "ubuntu", "os": "linux"}
+			d.RegisterFuncs(Funcs(d))
+			actual, err := d.ApplyTemplate([]byte(test.tmpl))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != test.expected {
+				t.Fatalf("Expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDistroIs(t *testing.T) {
+	f := &Facts{Distro: "ubuntu", DistroLike: []string{"debian"}}
+	tests := []struct {
+		names    []string
+		expected bool
+	}{
+		{[]string{"Ubuntu"}, true},
+		{[]string{"debian"}, true},
+		{[]string{"alpine", "rhel"}, false},
+	}
+	for _, test := range tests {
+		if actual := f.DistroIs(test.names...); actual != test.expected {
+			t.Fatalf("Expected %v for %v, got %v", test.expected, test.names, actual)
+		}
+	}
+}
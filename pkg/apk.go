@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"bufio"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/util"
+	"github.com/hashicorp/go-version"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ApkInstalledDB  = "/lib/apk/db/installed"
+	ApkRepositories = "/etc/apk/repositories"
+	ApkKeyDir       = "/etc/apk/keys"
+)
+
+type Apk struct {
+	*context.Context
+}
+
+func (a *Apk) Name() string {
+	return "apk"
+}
+
+// Read from the installed database where each package has a "P:" name line and "V:" version line.
+// Without the database nothing is installed yet.
+func (a *Apk) InstalledVersion(name string) (*version.Version, error) {
+	f, err := os.Open(ApkInstalledDB)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			found = false
+		} else if strings.HasPrefix(line, "P:") {
+			found = line[2:] == name
+		} else if found && strings.HasPrefix(line, "V:") {
+			return ParseVersion(line[2:])
+		}
+	}
+	return nil, scanner.Err()
+}
+
+func (a *Apk) Spec(name string, version string) string {
+	return name + "=" + version
+}
+
+func (a *Apk) Install(specs ...string) error {
+	return a.apk(append([]string{"add"}, specs...)...)
+}
+
+func (a *Apk) Remove(names ...string) error {
+	return a.apk(append([]string{"del"}, names...)...)
+}
+
+func (a *Apk) UpdateCache() error {
+	return a.apk("update")
+}
+
+// Appends the URL to the repositories file if not there
+func (a *Apk) AddRepo(repo *Repo) (bool, error) {
+	byts, err := ioutil.ReadFile(ApkRepositories)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	for _, line := range strings.Split(string(byts), "\n") {
+		if strings.TrimSpace(line) == repo.URL {
+			return false, nil
+		}
+	}
+	if len(byts) > 0 && byts[len(byts)-1] != '\n' {
+		byts = append(byts, '\n')
+	}
+	return util.WriteFileIfChanged(ApkRepositories, append(byts, []byte(repo.URL+"\n")...), 0644)
+}
+
+func (a *Apk) AddKey(key *Key) (string, bool, error) {
+	path := filepath.Join(ApkKeyDir, key.Name+".rsa.pub")
+	changed, err := writeKey(a.Context, key, path)
+	return path, changed, err
+}
+
+func (a *Apk) apk(args ...string) error {
+	_, err := run(a.Context, nil, "apk", args...)
+	return err
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"github.com/hashicorp/go-version"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	AptSourcesDir = "/etc/apt/sources.list.d"
+	AptKeyringDir = "/etc/apt/keyrings"
+)
+
+type Apt struct {
+	*context.Context
+}
+
+func (a *Apt) Name() string {
+	return "apt"
+}
+
+func (a *Apt) InstalledVersion(name string) (*version.Version, error) {
+	res, err := shell.RunResult(a.Context, &shell.RunOptions{AllowedExitCodes: []int{0, 1}},
+		"dpkg-query", "-W", "-f=${Status}\\n${Version}", name)
+	if err != nil {
+		return nil, err
+	}
+	// Unknown packages exit with 1 and removed ones don't have an "installed" status
+	lines := strings.Split(string(res.Stdout), "\n")
+	if res.ExitCode != 0 || len(lines) < 2 || !strings.HasSuffix(lines[0], " installed") {
+		return nil, nil
+	}
+	return ParseVersion(lines[1])
+}
+
+func (a *Apt) Spec(name string, version string) string {
+	return name + "=" + version
+}
+
+func (a *Apt) Install(specs ...string) error {
+	return a.aptGet(append([]string{"install", "-y", "--no-install-recommends"}, specs...)...)
+}
+
+func (a *Apt) Remove(names ...string) error {
+	return a.aptGet(append([]string{"remove", "-y"}, names...)...)
+}
+
+func (a *Apt) UpdateCache() error {
+	return a.aptGet("update")
+}
+
+func (a *Apt) AddRepo(repo *Repo) (bool, error) {
+	if repo.PPA != "" {
+		return a.addPPA(repo.PPA)
+	}
+	line := "deb "
+	if repo.Key != "" {
+		line += "[signed-by=" + repo.Key + "] "
+	}
+	line += strings.Join(append([]string{repo.URL, repo.Suite}, repo.Components...), " ") + "\n"
+	return util.WriteFileIfChanged(filepath.Join(AptSourcesDir, repo.Name+".list"), []byte(line), 0644)
+}
+
+func (a *Apt) AddKey(key *Key) (string, bool, error) {
+	// Armored keys are accepted as signed-by when the extension is .asc
+	path := filepath.Join(AptKeyringDir, key.Name+".asc")
+	changed, err := writeKey(a.Context, key, path)
+	return path, changed, err
+}
+
+// PPAs are only added if no source already references them
+func (a *Apt) addPPA(ppa string) (bool, error) {
+	ppaPath := strings.TrimPrefix(ppa, "ppa:")
+	infos, err := ioutil.ReadDir(AptSourcesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	for _, info := range infos {
+		byts, err := ioutil.ReadFile(filepath.Join(AptSourcesDir, info.Name()))
+		if err == nil && strings.Contains(string(byts), "/"+ppaPath+"/") {
+			return false, nil
+		}
+	}
+	if _, err := run(a.Context, nil, "add-apt-repository", "-y", ppa); err != nil {
+		return false, fmt.Errorf("Unable to add PPA %v: %v", ppa, err)
+	}
+	return true, nil
+}
+
+func (a *Apt) aptGet(args ...string) error {
+	// Never stop for a question
+	_, err := run(a.Context, []string{"DEBIAN_FRONTEND=noninteractive"}, "apt-get", args...)
+	return err
+}
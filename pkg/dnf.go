@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"github.com/hashicorp/go-version"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	YumReposDir = "/etc/yum.repos.d"
+	RpmKeyDir   = "/etc/pki/rpm-gpg"
+)
+
+// Uses dnf, or yum where dnf isn't available
+type Dnf struct {
+	*context.Context
+	Command string
+}
+
+func NewDnf(ctx *context.Context) *Dnf {
+	if !shell.HasCommand("dnf") && shell.HasCommand("yum") {
+		return &Dnf{ctx, "yum"}
+	}
+	return &Dnf{ctx, "dnf"}
+}
+
+func (d *Dnf) Name() string {
+	return d.Command
+}
+
+// Packages like the kernel can be installed at several versions at once, so this gives the highest
+func (d *Dnf) InstalledVersion(name string) (*version.Version, error) {
+	// Not installed exits with 1
+	res, err := shell.RunResult(d.Context, &shell.RunOptions{AllowedExitCodes: []int{0, 1}},
+		"rpm", "-q", "--qf", "%{VERSION}\\n", name)
+	if err != nil {
+		return nil, err
+	} else if res.ExitCode != 0 {
+		return nil, nil
+	}
+	return highestVersion(string(res.Stdout))
+}
+
+// Of the versions one per line
+func highestVersion(lines string) (*version.Version, error) {
+	var highest *version.Version
+	for _, line := range strings.Split(lines, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		vers, err := ParseVersion(line)
+		if err != nil {
+			return nil, err
+		}
+		if highest == nil || vers.GreaterThan(highest) {
+			highest = vers
+		}
+	}
+	if highest == nil {
+		return nil, fmt.Errorf("No package version in %q", lines)
+	}
+	return highest, nil
+}
+
+func (d *Dnf) Spec(name string, version string) string {
+	return name + "-" + version
+}
+
+func (d *Dnf) Install(specs ...string) error {
+	return d.dnf(append([]string{"install", "-y"}, specs...)...)
+}
+
+func (d *Dnf) Remove(names ...string) error {
+	return d.dnf(append([]string{"remove", "-y"}, names...)...)
+}
+
+func (d *Dnf) UpdateCache() error {
+	return d.dnf("makecache")
+}
+
+// Packages from the repo are checked against its key, so one is required unless it's insecure
+func (d *Dnf) AddRepo(repo *Repo) (bool, error) {
+	lines := []string{
+		"[" + repo.Name + "]",
+		"name=" + repo.Name,
+		"baseurl=" + repo.URL,
+		"enabled=1",
+	}
+	if repo.Key != "" {
+		key := repo.Key
+		if !strings.Contains(key, "://") {
+			key = "file://" + key
+		}
+		lines = append(lines, "gpgcheck=1", "gpgkey="+key)
+	} else if repo.Insecure {
+		lines = append(lines, "gpgcheck=0")
+	} else {
+		return false, fmt.Errorf("Repo %v has no key, set insecure to add it without signature checks", repo.Name)
+	}
+	return util.WriteFileIfChanged(filepath.Join(YumReposDir, repo.Name+".repo"),
+		[]byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func (d *Dnf) AddKey(key *Key) (string, bool, error) {
+	path := filepath.Join(RpmKeyDir, "RPM-GPG-KEY-"+key.Name)
+	changed, err := writeKey(d.Context, key, path)
+	if err == nil && changed {
+		if _, err = run(d.Context, nil, "rpm", "--import", path); err != nil {
+			err = fmt.Errorf("Unable to import key: %v", err)
+		}
+	}
+	return path, changed, err
+}
+
+func (d *Dnf) dnf(args ...string) error {
+	_, err := run(d.Context, nil, d.Command, args...)
+	return err
+}
@@ -0,0 +1,314 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/facts"
+	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"github.com/hashicorp/go-version"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A distro package manager. Use Packages instead of this directly to skip work already done.
+type Manager interface {
+	Name() string
+	// Nil if not installed
+	InstalledVersion(name string) (*version.Version, error)
+	// The name with the version in the form Install accepts
+	Spec(name string, version string) string
+	Install(specs ...string) error
+	Remove(names ...string) error
+	UpdateCache() error
+	// Returns whether anything changed
+	AddRepo(repo *Repo) (bool, error)
+	// Returns the path the key was saved at (to give as Repo.Key) and whether anything changed
+	AddKey(key *Key) (string, bool, error)
+}
+
+// A package repository. Which fields are used depends on the manager.
+type Repo struct {
+	// Used for the repo file name
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// For apt, e.g. "jammy"
+	Suite string `json:"suite"`
+	// For apt, e.g. ["main"]
+	Components []string `json:"components"`
+	// The key path from AddKey (or a URL for dnf/yum) the repo is signed with
+	Key string `json:"key"`
+	// For apt, a PPA like "ppa:openjdk-r/ppa" to add instead of the fields above
+	PPA string `json:"ppa"`
+	// For dnf/yum, turns off signature checks so the repo can be added without a key
+	Insecure bool `json:"insecure"`
+}
+
+// A repository signing key, ASCII armored for apt and dnf/yum and a public key for apk
+type Key struct {
+	// Used for the key file name
+	Name string `json:"name"`
+	// Fetched if Data is empty
+	URL  string `json:"url"`
+	Data string `json:"data"`
+}
+
+type Packages struct {
+	*context.Context
+	// Detected from facts on first use if nil
+	Manager Manager
+	// Set when repos or keys change so the cache is updated before installing
+	cacheStale   bool
+	cacheUpdated bool
+}
+
+func NewPackages(ctx *context.Context) *Packages {
+	return &Packages{Context: ctx}
+}
+
+// A package whose version is the installed one, erroring if not installed
+type Package struct {
+	*Packages
+	Name string
+}
+
+func (p *Packages) Package(name string) *Package {
+	return &Package{p, name}
+}
+
+func (p *Package) Version() (*version.Version, error) {
+	m, err := p.manager()
+	if err != nil {
+		return nil, err
+	}
+	vers, err := m.InstalledVersion(p.Name)
+	if err == nil && vers == nil {
+		err = fmt.Errorf("Package %v not installed", p.Name)
+	}
+	return vers, err
+}
+
+// Installs the packages that aren't already
+func (p *Packages) Install(names ...string) error {
+	m, err := p.manager()
+	if err != nil {
+		return err
+	}
+	needed := []string{}
+	for _, name := range names {
+		if vers, err := m.InstalledVersion(name); err != nil {
+			return err
+		} else if vers == nil {
+			needed = append(needed, name)
+		} else {
+			p.Debugf("Package %v already installed at %v", name, vers)
+		}
+	}
+	return p.install(m, needed)
+}
+
+// Removes the packages that are installed
+func (p *Packages) Remove(names ...string) error {
+	m, err := p.manager()
+	if err != nil {
+		return err
+	}
+	installed := []string{}
+	for _, name := range names {
+		if vers, err := m.InstalledVersion(name); err != nil {
+			return err
+		} else if vers != nil {
+			installed = append(installed, name)
+		}
+	}
+	if len(installed) == 0 {
+		return nil
+	}
+	p.Infof("Removing packages %v with %v", strings.Join(installed, ", "), m.Name())
+	return m.Remove(installed...)
+}
+
+// Makes sure the installed version of the package satisfies the constraints, installing the given
+// version (or the latest if empty) if not and checking the constraints again after
+func (p *Packages) EnsureVersion(name string, constraints version.Constraints, installVersion string) error {
+	m, err := p.manager()
+	if err != nil {
+		return err
+	}
+	pkg := p.Package(name)
+	if err := util.CheckVersion(pkg, name, constraints); err == nil {
+		p.Debugf("Package %v already satisfies %v", name, constraints)
+		return nil
+	}
+	spec := name
+	if installVersion != "" {
+		spec = m.Spec(name, installVersion)
+	}
+	if err := p.install(m, []string{spec}); err != nil {
+		return err
+	}
+	return util.CheckVersion(pkg, name, constraints)
+}
+
+func (p *Packages) UpdateCache() error {
+	m, err := p.manager()
+	if err != nil {
+		return err
+	}
+	p.Infof("Updating %v package cache", m.Name())
+	if err := m.UpdateCache(); err != nil {
+		return fmt.Errorf("Unable to update package cache: %v", err)
+	}
+	p.cacheStale, p.cacheUpdated = false, true
+	return nil
+}
+
+// Adds the repo if not already present
+func (p *Packages) AddRepo(repo *Repo) error {
+	m, err := p.manager()
+	if err != nil {
+		return err
+	}
+	changed, err := m.AddRepo(repo)
+	if err != nil {
+		return fmt.Errorf("Unable to add repo %v: %v", repo.Name, err)
+	}
+	p.cacheStale = p.cacheStale || changed
+	return nil
+}
+
+// Adds the key if not already present and returns the path it's at
+func (p *Packages) AddKey(key *Key) (string, error) {
+	m, err := p.manager()
+	if err != nil {
+		return "", err
+	}
+	path, changed, err := m.AddKey(key)
+	if err != nil {
+		return "", fmt.Errorf("Unable to add key %v: %v", key.Name, err)
+	}
+	p.cacheStale = p.cacheStale || changed
+	return path, nil
+}
+
+// Updates the cache first if repos changed, or after a failure if it wasn't updated this run
+func (p *Packages) install(m Manager, specs []string) error {
+	if len(specs) == 0 {
+		return nil
+	}
+	if p.cacheStale {
+		if err := p.UpdateCache(); err != nil {
+			return err
+		}
+	}
+	p.Infof("Installing packages %v with %v", strings.Join(specs, ", "), m.Name())
+	err := m.Install(specs...)
+	if err != nil && !p.cacheUpdated {
+		p.Debugf("Install failed, updating package cache and trying again: %v", err)
+		if err := p.UpdateCache(); err != nil {
+			return err
+		}
+		err = m.Install(specs...)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to install %v: %v", strings.Join(specs, ", "), err)
+	}
+	return nil
+}
+
+// The manager in use, detecting it if not set
+func (p *Packages) Detect() (Manager, error) {
+	return p.manager()
+}
+
+func (p *Packages) manager() (Manager, error) {
+	if p.Manager == nil {
+		f, err := facts.Get(p.Data)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to detect package manager: %v", err)
+		}
+		if p.Manager = DetectManager(p.Context, f); p.Manager == nil {
+			return nil, fmt.Errorf("No supported package manager for distro %v", f.Distro)
+		}
+	}
+	return p.Manager, nil
+}
+
+// The manager for the distro, falling back to whichever is on the path. Nil if none.
+func DetectManager(ctx *context.Context, f *facts.Facts) Manager {
+	switch {
+	case f.DistroIs("debian", "ubuntu"):
+		return &Apt{ctx}
+	case f.DistroIs("fedora", "rhel", "centos"):
+		return NewDnf(ctx)
+	case f.DistroIs("alpine"):
+		return &Apk{ctx}
+	case shell.HasCommand("apt-get"):
+		return &Apt{ctx}
+	case shell.HasCommand("dnf"), shell.HasCommand("yum"):
+		return NewDnf(ctx)
+	case shell.HasCommand("apk"):
+		return &Apk{ctx}
+	}
+	return nil
+}
+
+var leadingVersion = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*`)
+
+// Distro versions carry an epoch ("1:"), a release ("-1ubuntu2", "-r0"), and other extras this
+// drops to leave just the upstream version
+func ParseVersion(str string) (*version.Version, error) {
+	str = strings.TrimSpace(str)
+	if i := strings.Index(str, ":"); i >= 0 {
+		str = str[i+1:]
+	}
+	match := leadingVersion.FindString(str)
+	if match == "" {
+		return nil, fmt.Errorf("Unrecognized package version %v", str)
+	}
+	return version.NewVersion(match)
+}
+
+// How long fetching a key from its URL can take
+var KeyDownloadTimeout = time.Minute
+
+// Gets the key from the data or URL and writes it to the path if different, returning whether it
+// was written
+func writeKey(ctx *context.Context, key *Key, path string) (bool, error) {
+	byts := []byte(key.Data)
+	if key.Data == "" {
+		req, err := http.NewRequest("GET", key.URL, nil)
+		if err != nil {
+			return false, fmt.Errorf("Invalid key URL: %v", err)
+		}
+		client := &http.Client{Timeout: KeyDownloadTimeout}
+		resp, err := client.Do(req.WithContext(ctx.StdContext()))
+		if err != nil && ctx.Err() != nil {
+			return false, shell.ErrCancelled
+		} else if err != nil {
+			return false, fmt.Errorf("Unable to fetch key: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("Unable to fetch key: %v", resp.Status)
+		}
+		if byts, err = ioutil.ReadAll(resp.Body); err != nil {
+			return false, fmt.Errorf("Unable to read key: %v", err)
+		}
+	}
+	return util.WriteFileIfChanged(path, byts, 0644)
+}
+
+// Runs a package manager command with the given extra env vars. Every manager runs commands this
+// way so failures all have the command's stderr.
+func run(ctx *context.Context, env []string, name string, args ...string) (*shell.Result, error) {
+	cmd := shell.Command(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return shell.RunCommandResult(ctx, cmd, nil)
+}
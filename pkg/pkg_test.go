@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected string
+		wantErr  bool
+	}{
+		{"1.2.3", "1.2.3", false},
+		{"1:2.34-0ubuntu3.2", "2.34.0", false},
+		{"17.0.9_p9-r0", "17.0.9", false},
+		{"2.9.13-5.el9", "2.9.13", false},
+		{" 11\n", "11.0.0", false},
+		{"1.2.3+dfsg-1", "1.2.3", false},
+		{"none", "", true},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		vers, err := ParseVersion(test.str)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("Expected error %v for %q, got %v", test.wantErr, test.str, err)
+		}
+		if err == nil && vers.String() != test.expected {
+			t.Fatalf("Expected %v for %q, got %v", test.expected, test.str, vers)
+		}
+	}
+}
+
+func TestHighestVersion(t *testing.T) {
+	tests := []struct {
+		lines    string
+		expected string
+		wantErr  bool
+	}{
+		{"5.14.0\n", "5.14.0", false},
+		{"5.14.0\n5.9.2\n5.14.1\n", "5.14.1", false},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		vers, err := highestVersion(test.lines)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("Expected error %v for %q, got %v", test.wantErr, test.lines, err)
+		}
+		if err == nil && vers.String() != test.expected {
+			t.Fatalf("Expected %v for %q, got %v", test.expected, test.lines, vers)
+		}
+	}
+}
+
+func TestApkInstalledVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syst-apk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(prev string) { ApkInstalledDB = prev }(ApkInstalledDB)
+	db := "C:Q1abc=\nP:musl\nV:1.2.4-r2\nA:x86_64\n\nP:openjdk17-jre-headless\nV:17.0.9_p9-r0\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "installed"), []byte(db), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		db       string
+		name     string
+		expected string
+	}{
+		{"installed", "musl", "1.2.4"},
+		{"installed", "openjdk17-jre-headless", "17.0.9"},
+		{"installed", "nope", ""},
+		// Not made until the first package is installed
+		{"missing", "musl", ""},
+	}
+	for _, test := range tests {
+		ApkInstalledDB = filepath.Join(dir, test.db)
+		vers, err := (&Apk{}).InstalledVersion(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := ""
+		if vers != nil {
+			actual = vers.String()
+		}
+		if actual != test.expected {
+			t.Fatalf("Expected %q for %v, got %q", test.expected, test.name, actual)
+		}
+	}
+}
+
+func TestDnfAddRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syst-dnf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(prev string) { YumReposDir = prev }(YumReposDir)
+	YumReposDir = dir
+	tests := []struct {
+		repo     *Repo
+		expected string
+		wantErr  bool
+	}{
+		{&Repo{Name: "signed", URL: "https://x/el9", Key: "/etc/pki/rpm-gpg/RPM-GPG-KEY-x"},
+			"[signed]\nname=signed\nbaseurl=https://x/el9\nenabled=1\ngpgcheck=1\n" +
+				"gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-x\n", false},
+		{&Repo{Name: "remote-key", URL: "https://x/el9", Key: "https://x/key.asc"},
+			"[remote-key]\nname=remote-key\nbaseurl=https://x/el9\nenabled=1\ngpgcheck=1\n" +
+				"gpgkey=https://x/key.asc\n", false},
+		{&Repo{Name: "insecure", URL: "https://x/el9", Insecure: true},
+			"[insecure]\nname=insecure\nbaseurl=https://x/el9\nenabled=1\ngpgcheck=0\n", false},
+		{&Repo{Name: "unsigned", URL: "https://x/el9"}, "", true},
+	}
+	for _, test := range tests {
+		changed, err := (&Dnf{Command: "dnf"}).AddRepo(test.repo)
+		if test.wantErr {
+			if err == nil {
+				t.Fatalf("Expected error for %v", test.repo.Name)
+			}
+			if _, err := os.Stat(filepath.Join(dir, test.repo.Name+".repo")); !os.IsNotExist(err) {
+				t.Fatalf("Expected no repo file for %v", test.repo.Name)
+			}
+			continue
+		}
+		if err != nil || !changed {
+			t.Fatalf("Expected %v to be added, got %v (%v)", test.repo.Name, changed, err)
+		}
+		byts, _ := ioutil.ReadFile(filepath.Join(dir, test.repo.Name+".repo"))
+		if string(byts) != test.expected {
+			t.Fatalf("Expected %q, got %q", test.expected, byts)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/data"
 	"github.com/cretz/systrument/shell"
 	"github.com/cretz/systrument/util"
 	"io/ioutil"
@@ -34,16 +35,18 @@ type SSH struct {
 	PrivateKey    string `json:"privateKey"`
 	Sudo          bool   `json:"sudo"`
 	IgnoreHostKey bool   `json:"ignoreHostKey"`
+	// Runs the remote command in a terminal for tools that require one
+	Pty bool `json:"pty"`
 }
 
 func RemoteIfPresent(ctx *context.Context) (*Remote, error) {
-	r := &Remote{ctx: ctx}
-	if err := ctx.Data.UnmarshalJSON(&r); err != nil {
-		return nil, fmt.Errorf("Unable to fetch remote info: %v", err)
-	}
-	if r.Server == nil {
+	if server, _ := ctx.Data.Get("server"); server == nil {
 		return nil, nil
 	}
+	r := &Remote{ctx: ctx, Server: &RemoteServer{}}
+	if err := ctx.Data.UnmarshalKey("server", r.Server); err != nil {
+		return nil, fmt.Errorf("Unable to fetch remote info: %v", err)
+	}
 	if errs := r.Server.validate(); len(errs) > 0 {
 		return nil, fmt.Errorf("Invalid remote server: %v", util.JoinErrors(errs))
 	}
@@ -80,6 +83,16 @@ func (r *Remote) RunRemotely() error {
 		r.ssh = ssh
 	}
 	defer r.ssh.close()
+	// Closing the connection stops any transfer in progress on cancel
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			r.ssh.close()
+		case <-finished:
+		}
+	}()
 	remoteTempFile := "/tmp/" + filepath.Base(localFile)
 	r.ctx.Debugf("Sending local exe %v to remote path %v", localFile, remoteTempFile)
 	if err := r.ssh.sendFile(localFile, remoteTempFile, 0775); err != nil {
@@ -138,7 +151,7 @@ func (r *Remote) buildForRemote() (string, error) {
 	}
 	cmd.Env = append(cmd.Env, "GOOS="+osName, "GOARCH="+arch)
 	r.ctx.Infof("Building executable for remote OS %v and arch %v", osName, arch)
-	if err = cmd.Run(); err != nil {
+	if err = shell.StartAndWait(r.ctx, cmd); err != nil {
 		os.Remove(f.Name())
 		return "", fmt.Errorf("Failed to build custom remote binary: %v", err)
 	}
@@ -147,11 +160,29 @@ func (r *Remote) buildForRemote() (string, error) {
 
 func (r *Remote) handleRemoteRequest(request string) (string, error) {
 	if request == "get-context-data" {
-		byts, err := json.Marshal(r.ctx.Data.Values)
+		byts, err := json.Marshal(r.ctx.RemoteData())
 		if err != nil {
 			return "", fmt.Errorf("Unable to marshal context data: %v", err)
 		}
 		return string(byts), nil
+	} else if request == "get-remote-templates" {
+		byts, err := json.Marshal(r.ctx.RemoteTemplates)
+		if err != nil {
+			return "", fmt.Errorf("Unable to marshal remote templates: %v", err)
+		}
+		return string(byts), nil
+	} else if request == "get-overrides" {
+		byts, err := json.Marshal(r.ctx.Overrides)
+		if err != nil {
+			return "", fmt.Errorf("Unable to marshal overrides: %v", err)
+		}
+		return string(byts), nil
+	} else if strings.HasPrefix(request, "prompt ") {
+		req := &data.PromptRequest{}
+		if err := json.Unmarshal([]byte(request[7:]), req); err != nil {
+			return "", fmt.Errorf("Malformed prompt request: %v", err)
+		}
+		return r.ctx.Data.Prompter.Prompt(req)
 	} else if strings.HasPrefix(request, "send-file ") {
 		files := strings.Split(request[10:], " --to-- ")
 		if len(files) != 2 {
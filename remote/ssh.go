@@ -8,10 +8,12 @@ import (
 	"github.com/cretz/systrument/util"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,6 +24,8 @@ type sshConn struct {
 }
 
 func newSshConn(ctx *context.Context, server *RemoteServer) (*sshConn, error) {
+	// The password is typed into sudo prompts, so make sure it never shows up in output
+	ctx.AddSecrets(server.SSH.Pass)
 	config := &ssh.ClientConfig{
 		User: server.SSH.User,
 		Auth: []ssh.AuthMethod{
@@ -50,7 +54,17 @@ func newSshConn(ctx *context.Context, server *RemoteServer) (*sshConn, error) {
 	if port == 0 {
 		port = 22
 	}
-	client, err := ssh.Dial("tcp", server.Host+":"+strconv.Itoa(port), config)
+	retry, err := ctx.RetryPolicy("ssh")
+	if err != nil {
+		return nil, err
+	}
+	// Bad credentials won't get any better
+	retry.Retryable = func(err error) bool { return !strings.Contains(err.Error(), "unable to authenticate") }
+	var client *ssh.Client
+	err = retry.Do(func(attempt int) (err error) {
+		client, err = ssh.Dial("tcp", server.Host+":"+strconv.Itoa(port), config)
+		return
+	})
 	if err != nil {
 		return nil, fmt.Errorf("Unable to connect to %v over SSH: %v", server.Host, err)
 	}
@@ -65,7 +79,16 @@ func (s *sshConn) close() error {
 	return s.client.Close()
 }
 
+// Retries the whole transfer on failure
 func (s *sshConn) sendFile(localPath string, remotePath string, mode os.FileMode) error {
+	retry, err := s.RetryPolicy("transfer")
+	if err != nil {
+		return err
+	}
+	return retry.Do(func(attempt int) error { return s.sendFileOnce(localPath, remotePath, mode) })
+}
+
+func (s *sshConn) sendFileOnce(localPath string, remotePath string, mode os.FileMode) error {
 	sf, err := sftp.NewClient(s.client)
 	if err != nil {
 		return fmt.Errorf("Unable to initiate SFTP connection: %v", err)
@@ -73,7 +96,7 @@ func (s *sshConn) sendFile(localPath string, remotePath string, mode os.FileMode
 	defer sf.Close()
 	localFile, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("Unable to read file at local path %v: %v", localPath, err)
+		return util.Permanent(fmt.Errorf("Unable to read file at local path %v: %v", localPath, err))
 	}
 	defer localFile.Close()
 	remoteFile, err := sf.Create(remotePath)
@@ -107,19 +130,23 @@ func (s *sshConn) sendFile(localPath string, remotePath string, mode os.FileMode
 }
 
 func (s *sshConn) runSudoCommand(sess *ssh.Session, stdin io.Writer, cmd string) error {
-	// Wrap the output
-	if s.DebugEnabled() {
-		debugOutWriter := util.NewDebugLogWriter("SSH OUT:", s.Context)
+	// Wrap the output, showing it live if streaming
+	if s.DebugEnabled() || s.StreamOutput {
+		outWriter := util.NewLineLogWriter("["+s.server.Host+"] SSH OUT:", s.Context, s.StreamOutput)
+		// Pipe requests are not output
+		outWriter.Filter = func(line string) bool { return !strings.Contains(line, "[syst-request-") }
+		defer outWriter.Close()
 		if sess.Stdout != nil {
-			sess.Stdout = io.MultiWriter(sess.Stdout, debugOutWriter)
+			sess.Stdout = io.MultiWriter(sess.Stdout, outWriter)
 		} else {
-			sess.Stdout = debugOutWriter
+			sess.Stdout = outWriter
 		}
-		debugErrWriter := util.NewDebugLogWriter("SSH ERR:", s.Context)
+		errWriter := util.NewLineLogWriter("["+s.server.Host+"] SSH ERR:", s.Context, s.StreamOutput)
+		defer errWriter.Close()
 		if sess.Stderr != nil {
-			sess.Stderr = io.MultiWriter(sess.Stderr, debugErrWriter)
+			sess.Stderr = io.MultiWriter(sess.Stderr, errWriter)
 		} else {
-			sess.Stderr = debugErrWriter
+			sess.Stderr = errWriter
 		}
 	}
 	// We need a checker to enter the password
@@ -135,8 +162,64 @@ func (s *sshConn) runSudoCommand(sess *ssh.Session, stdin io.Writer, cmd string)
 	} else {
 		sess.Stderr = io.MultiWriter(sess.Stderr, passwordTyper)
 	}
-	if err := sess.Run("sudo -S " + cmd); err != nil {
-		return fmt.Errorf("Error running command %v: %v", cmd, err)
+	if s.server.SSH.Pty {
+		stopWatching, err := s.requestPty(sess)
+		if err != nil {
+			return err
+		}
+		defer stopWatching()
+	}
+	if err := sess.Start("sudo -S " + cmd); err != nil {
+		return fmt.Errorf("Error starting command %v: %v", cmd, err)
+	}
+	c := make(chan error, 1)
+	go func() { c <- sess.Wait() }()
+	select {
+	case err := <-c:
+		if err != nil && s.Err() != nil {
+			return shell.ErrCancelled
+		} else if err != nil {
+			return fmt.Errorf("Error running command %v: %v", cmd, err)
+		}
+		return nil
+	case <-s.Done():
+		// Not all servers support signals, but the remote side also stops once the session closes
+		s.Debugf("Cancelling remote command")
+		sess.Signal(ssh.SIGTERM)
+		sess.Close()
+		return shell.ErrCancelled
+	}
+}
+
+// The pipe requests and responses go over the terminal, so it is set to pass everything through
+// untouched. Stderr is mixed into stdout by the terminal, which the pipe's framing tolerates. The
+// window size follows ours until the returned function is called.
+func (s *sshConn) requestPty(sess *ssh.Session) (func(), error) {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:   0,
+		ssh.ICANON: 0,
+		ssh.ISIG:   0,
+		ssh.IEXTEN: 0,
+		ssh.IXON:   0,
+		ssh.ICRNL:  0,
+		ssh.OPOST:  0,
+	}
+	width, height := terminalSize()
+	if err := sess.RequestPty("xterm", height, width, modes); err != nil {
+		return nil, fmt.Errorf("Unable to request PTY: %v", err)
+	}
+	return shell.WatchWindowSize(func() {
+		width, height := terminalSize()
+		if err := sess.WindowChange(height, width); err != nil {
+			s.Debugf("Unable to change remote window size: %v", err)
+		}
+	}), nil
+}
+
+// Defaults to 80x24 if we're not in a terminal
+func terminalSize() (width int, height int) {
+	if width, height, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil {
+		return width, height
 	}
-	return nil
+	return 80, 24
 }
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/facts"
+	"github.com/cretz/systrument/shell"
+	"os"
+	"path/filepath"
+)
+
+// A service managed by the host's init system: systemd (via systemctl), SysV (via service and
+// update-rc.d or chkconfig), or OpenRC (via rc-service and rc-update). Other init systems are
+// treated like SysV if they have the service command.
+type Service struct {
+	*context.Context
+	Name string
+	// One of the facts.Init* values. If empty, it is detected from facts on first use.
+	InitSystem string
+}
+
+type Status struct {
+	Running bool
+	// Whether it starts at boot
+	Enabled bool
+}
+
+// Replaced in tests
+var (
+	hasCommand = shell.HasCommand
+	runResult  = shell.RunResult
+)
+
+func NewService(ctx *context.Context, name string) *Service {
+	return &Service{Context: ctx, Name: name}
+}
+
+func (s *Service) Start() error {
+	return s.action("start")
+}
+
+func (s *Service) Stop() error {
+	return s.action("stop")
+}
+
+func (s *Service) Restart() error {
+	return s.action("restart")
+}
+
+func (s *Service) Reload() error {
+	return s.action("reload")
+}
+
+// Reloads if the service supports it, otherwise restarts
+func (s *Service) ReloadOrRestart() error {
+	initSystem, err := s.initSystem()
+	if err != nil {
+		return err
+	}
+	if initSystem == facts.InitSystemd {
+		return s.run("reload or restart", "systemctl", "reload-or-restart", s.Name)
+	}
+	if err := s.Reload(); err != nil {
+		s.Debugf("Reload of %v failed, restarting instead: %v", s.Name, err)
+		return s.Restart()
+	}
+	return nil
+}
+
+// Makes the service start at boot
+func (s *Service) Enable() error {
+	initSystem, err := s.initSystem()
+	if err != nil {
+		return err
+	}
+	switch initSystem {
+	case facts.InitSystemd:
+		return s.run("enable", "systemctl", "enable", s.Name)
+	case facts.InitOpenRC:
+		return s.run("enable", "rc-update", "add", s.Name, "default")
+	default:
+		if hasCommand("chkconfig") {
+			return s.run("enable", "chkconfig", s.Name, "on")
+		}
+		return s.run("enable", "update-rc.d", s.Name, "defaults")
+	}
+}
+
+func (s *Service) Disable() error {
+	initSystem, err := s.initSystem()
+	if err != nil {
+		return err
+	}
+	switch initSystem {
+	case facts.InitSystemd:
+		return s.run("disable", "systemctl", "disable", s.Name)
+	case facts.InitOpenRC:
+		return s.run("disable", "rc-update", "del", s.Name, "default")
+	default:
+		if hasCommand("chkconfig") {
+			return s.run("disable", "chkconfig", s.Name, "off")
+		}
+		return s.run("disable", "update-rc.d", s.Name, "disable")
+	}
+}
+
+func (s *Service) Status() (*Status, error) {
+	initSystem, err := s.initSystem()
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	switch initSystem {
+	case facts.InitSystemd:
+		if status.Running, err = s.succeeds("systemctl", "is-active", "--quiet", s.Name); err != nil {
+			return nil, err
+		}
+		status.Enabled, err = s.succeeds("systemctl", "is-enabled", "--quiet", s.Name)
+	case facts.InitOpenRC:
+		if status.Running, err = s.succeeds("rc-service", s.Name, "status"); err != nil {
+			return nil, err
+		}
+		_, err = os.Stat(filepath.Join("/etc/runlevels/default", s.Name))
+		status.Enabled, err = err == nil, nil
+	default:
+		if status.Running, err = s.succeeds("service", s.Name, "status"); err != nil {
+			return nil, err
+		}
+		if hasCommand("chkconfig") {
+			status.Enabled, err = s.succeeds("chkconfig", s.Name)
+		} else {
+			// Enabled means a start link in a multi-user runlevel
+			matches, _ := filepath.Glob("/etc/rc[2-5].d/S[0-9][0-9]" + s.Name)
+			status.Enabled = len(matches) > 0
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (s *Service) action(action string) error {
+	initSystem, err := s.initSystem()
+	if err != nil {
+		return err
+	}
+	switch initSystem {
+	case facts.InitSystemd:
+		return s.run(action, "systemctl", action, s.Name)
+	case facts.InitOpenRC:
+		return s.run(action, "rc-service", s.Name, action)
+	default:
+		return s.run(action, "service", s.Name, action)
+	}
+}
+
+func (s *Service) initSystem() (string, error) {
+	if s.InitSystem == "" {
+		f, err := facts.Get(s.Data)
+		if err != nil {
+			return "", fmt.Errorf("Unable to detect init system: %v", err)
+		}
+		s.InitSystem = f.InitSystem
+	}
+	switch s.InitSystem {
+	case facts.InitSystemd, facts.InitOpenRC, facts.InitSysV:
+		return s.InitSystem, nil
+	}
+	// Most init systems we can't detect (e.g. upstart or runit's sv shims) still have the service
+	// command, so treat them like SysV
+	if hasCommand("service") {
+		s.Debugf("Using the service command for service %v on init system %v", s.Name, s.InitSystem)
+		return facts.InitSysV, nil
+	}
+	return "", fmt.Errorf("Unsupported init system %v for service %v: no service command", s.InitSystem, s.Name)
+}
+
+func (s *Service) run(action string, name string, args ...string) error {
+	if _, err := runResult(s.Context, nil, name, args...); err != nil {
+		return fmt.Errorf("Unable to %v service %v: %v", action, s.Name, err)
+	}
+	return nil
+}
+
+// Whether the command exits with 0. It only errors if the command can't be run.
+func (s *Service) succeeds(name string, args ...string) (bool, error) {
+	res, err := runResult(s.Context, nil, name, args...)
+	if res.ExitCode < 0 {
+		return false, err
+	}
+	return res.ExitCode == 0, nil
+}
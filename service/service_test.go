@@ -0,0 +1,110 @@
+package service
+
+import (
+	"errors"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/data"
+	"github.com/cretz/systrument/facts"
+	"github.com/cretz/systrument/shell"
+	"github.com/cretz/systrument/util"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestService(t *testing.T) {
+	start := func(s *Service) error { return s.Start() }
+	enable := func(s *Service) error { return s.Enable() }
+	disable := func(s *Service) error { return s.Disable() }
+	reloadOrRestart := func(s *Service) error { return s.ReloadOrRestart() }
+	status := func(s *Service) error {
+		status, err := s.Status()
+		if err == nil && !status.Running {
+			return errors.New("Expected running")
+		}
+		return err
+	}
+	tests := []struct {
+		name       string
+		initSystem string
+		// Commands on the PATH
+		commands []string
+		// Command lines that exit with 1
+		fail     []string
+		call     func(s *Service) error
+		expected []string
+		wantErr  string
+	}{
+		{"systemd start", facts.InitSystemd, nil, nil, start, []string{"systemctl start nginx"}, ""},
+		{"systemd enable", facts.InitSystemd, nil, nil, enable, []string{"systemctl enable nginx"}, ""},
+		{"systemd reload or restart", facts.InitSystemd, nil, nil, reloadOrRestart,
+			[]string{"systemctl reload-or-restart nginx"}, ""},
+		{"systemd status", facts.InitSystemd, nil, []string{"systemctl is-enabled --quiet nginx"}, status,
+			[]string{"systemctl is-active --quiet nginx", "systemctl is-enabled --quiet nginx"}, ""},
+		{"openrc start", facts.InitOpenRC, nil, nil, start, []string{"rc-service nginx start"}, ""},
+		{"openrc enable", facts.InitOpenRC, nil, nil, enable, []string{"rc-update add nginx default"}, ""},
+		{"openrc disable", facts.InitOpenRC, nil, nil, disable, []string{"rc-update del nginx default"}, ""},
+		{"openrc status", facts.InitOpenRC, nil, nil, status, []string{"rc-service nginx status"}, ""},
+		{"sysv start", facts.InitSysV, nil, nil, start, []string{"service nginx start"}, ""},
+		{"sysv enable with update-rc.d", facts.InitSysV, nil, nil, enable, []string{"update-rc.d nginx defaults"}, ""},
+		{"sysv disable with update-rc.d", facts.InitSysV, nil, nil, disable, []string{"update-rc.d nginx disable"}, ""},
+		{"sysv enable with chkconfig", facts.InitSysV, []string{"chkconfig"}, nil, enable,
+			[]string{"chkconfig nginx on"}, ""},
+		{"sysv status with chkconfig", facts.InitSysV, []string{"chkconfig"}, nil, status,
+			[]string{"service nginx status", "chkconfig nginx"}, ""},
+		{"sysv reload falls back to restart", facts.InitSysV, nil, []string{"service nginx reload"}, reloadOrRestart,
+			[]string{"service nginx reload", "service nginx restart"}, ""},
+		{"unknown with service", facts.InitUnknown, []string{"service"}, nil, start,
+			[]string{"service nginx start"}, ""},
+		{"unknown without service", facts.InitUnknown, nil, nil, start, nil, "no service command"},
+		{"failure", facts.InitSystemd, nil, []string{"systemctl stop nginx"}, func(s *Service) error { return s.Stop() },
+			[]string{"systemctl stop nginx"}, "Unable to stop service nginx"},
+	}
+	defer func() { hasCommand, runResult = shell.HasCommand, shell.RunResult }()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hasCommand = func(name string) bool {
+				for _, command := range test.commands {
+					if command == name {
+						return true
+					}
+				}
+				return false
+			}
+			var ran []string
+			runResult = func(ctx *context.Context, opts *shell.RunOptions, name string, args ...string) (*shell.Result, error) {
+				res := &shell.Result{CommandLine: strings.Join(append([]string{name}, args...), " ")}
+				ran = append(ran, res.CommandLine)
+				for _, fail := range test.fail {
+					if fail == res.CommandLine {
+						res.ExitCode = 1
+						return res, &shell.ResultError{Result: res, Err: errors.New("Exit code 1 not allowed")}
+					}
+				}
+				return res, nil
+			}
+			s := NewService(newTestContext(), "nginx")
+			s.InitSystem = test.initSystem
+			err := test.call(s)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("Expected error containing %q, got %v", test.wantErr, err)
+			}
+			if !reflect.DeepEqual(ran, test.expected) {
+				t.Fatalf("Expected to run %q, ran %q", test.expected, ran)
+			}
+		})
+	}
+}
+
+func newTestContext() *context.Context {
+	redactor := util.NewRedactor()
+	return &context.Context{
+		Logger:   util.GoLoggerWrapper(log.New(ioutil.Discard, "", 0), false, redactor),
+		Redactor: redactor,
+		Data:     data.NewData(),
+	}
+}
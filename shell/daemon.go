@@ -0,0 +1,163 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var DefaultDaemonStopTimeout = 10 * time.Second
+
+// A process detached from us (and any terminal) that keeps running after we exit, tracked via a
+// pidfile so it can be checked and stopped later, even from another run
+type Daemon struct {
+	PidFile string
+	// Stdout and stderr are appended here. If empty, output is discarded.
+	LogFile string
+	// How long Stop waits after the graceful signal before killing. Zero uses
+	// DefaultDaemonStopTimeout.
+	StopTimeout time.Duration
+}
+
+func (d *Daemon) Start(ctx *context.Context, name string, args ...string) error {
+	return d.StartCommand(ctx, exec.Command(name, args...))
+}
+
+// Starts an unstarted command as the daemon. Its stdio is replaced. Fails if already running.
+func (d *Daemon) StartCommand(ctx *context.Context, cmd *exec.Cmd) error {
+	if pid, running, err := d.Status(); err != nil {
+		return err
+	} else if running {
+		return fmt.Errorf("Already running with pid %v per %v", pid, d.PidFile)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+	if d.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(d.LogFile), 0755); err != nil {
+			return fmt.Errorf("Unable to create log dir: %v", err)
+		}
+		logFile, err := os.OpenFile(d.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Unable to open log file: %v", err)
+		}
+		// The child has its own copy once started
+		defer logFile.Close()
+		cmd.Stdout, cmd.Stderr = logFile, logFile
+	}
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start: %v", err)
+	}
+	// Reap it if it exits while we're still around so it doesn't look alive
+	go cmd.Wait()
+	pid := cmd.Process.Pid
+	if err := d.writePidFile(pid); err != nil {
+		// Nothing could find or stop it later
+		stopProcessGroup(pid, true)
+		return err
+	}
+	ctx.Debugf("Started %v in the background with pid %v", CommandLine(cmd), pid)
+	return nil
+}
+
+// The pid is followed by the process's start time so a later process reusing the pid isn't mistaken
+// for the daemon
+func (d *Daemon) writePidFile(pid int) error {
+	if err := os.MkdirAll(filepath.Dir(d.PidFile), 0755); err != nil {
+		return fmt.Errorf("Unable to create pidfile dir: %v", err)
+	}
+	contents := strconv.Itoa(pid) + "\n"
+	if startTime, err := processStartTime(pid); err == nil {
+		contents += startTime + "\n"
+	}
+	if err := ioutil.WriteFile(d.PidFile, []byte(contents), 0644); err != nil {
+		return fmt.Errorf("Unable to write pidfile: %v", err)
+	}
+	return nil
+}
+
+// The pid from the pidfile, or 0 if there is no pidfile
+func (d *Daemon) Pid() (int, error) {
+	pid, _, err := d.readPidFile()
+	return pid, err
+}
+
+// The pid and start time (empty if not recorded) from the pidfile
+func (d *Daemon) readPidFile() (int, string, error) {
+	byts, err := ioutil.ReadFile(d.PidFile)
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	} else if err != nil {
+		return 0, "", fmt.Errorf("Unable to read pidfile: %v", err)
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(byts)), "\n", 2)
+	pid, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil || pid <= 0 {
+		return 0, "", fmt.Errorf("Invalid pidfile %v", d.PidFile)
+	}
+	startTime := ""
+	if len(lines) > 1 {
+		startTime = strings.TrimSpace(lines[1])
+	}
+	return pid, startTime, nil
+}
+
+// The pid and whether it is alive. A live process with a different start time than recorded has
+// only reused the pid and doesn't count.
+func (d *Daemon) Status() (int, bool, error) {
+	pid, startTime, err := d.readPidFile()
+	if err != nil || pid == 0 || !processAlive(pid) {
+		return pid, false, err
+	}
+	if startTime != "" {
+		if actual, err := processStartTime(pid); err == nil && actual != startTime {
+			return pid, false, nil
+		}
+	}
+	return pid, true, nil
+}
+
+func (d *Daemon) Running() (bool, error) {
+	_, running, err := d.Status()
+	return running, err
+}
+
+// Signals the daemon (and everything it started) to stop, killing it if it hasn't by the stop
+// timeout, then removes the pidfile. Not running, including a stale pidfile, is not an error.
+func (d *Daemon) Stop(ctx *context.Context) error {
+	pid, running, err := d.Status()
+	if err != nil {
+		return err
+	}
+	if running {
+		timeout := d.StopTimeout
+		if timeout == 0 {
+			timeout = DefaultDaemonStopTimeout
+		}
+		ctx.Debugf("Stopping pid %v", pid)
+		if err := stopProcessGroup(pid, false); err != nil {
+			return fmt.Errorf("Unable to stop pid %v: %v", pid, err)
+		}
+		deadline := time.Now().Add(timeout)
+		for processAlive(pid) && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if processAlive(pid) {
+			ctx.Infof("Pid %v did not stop within %v, killing", pid, timeout)
+			if err := stopProcessGroup(pid, true); err != nil {
+				return fmt.Errorf("Unable to kill pid %v: %v", pid, err)
+			}
+		}
+	}
+	if pid != 0 {
+		if err := os.Remove(d.PidFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Unable to remove pidfile: %v", err)
+		}
+	}
+	return nil
+}
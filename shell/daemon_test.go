@@ -0,0 +1,173 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestDaemon(t *testing.T) (*Daemon, func()) {
+	dir, err := ioutil.TempDir("", "syst-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{PidFile: filepath.Join(dir, "run", "d.pid"), LogFile: filepath.Join(dir, "log", "d.log")}
+	return d, func() {
+		if pid, _ := d.Pid(); pid != 0 {
+			stopProcessGroup(pid, true)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// Waits for the daemon to log the text
+func waitForLog(t *testing.T, d *Daemon, text string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if byts, _ := ioutil.ReadFile(d.LogFile); strings.Contains(string(byts), text) {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected log to contain %q, got %q", text, byts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDaemonStartStop(t *testing.T) {
+	d, cleanUp := newTestDaemon(t)
+	defer cleanUp()
+	ctx := newTestContext()
+	if err := d.Start(ctx, "sh", "-c", "echo started; exec sleep 30"); err != nil {
+		t.Fatal(err)
+	}
+	pid, running, err := d.Status()
+	if err != nil || !running || pid == 0 {
+		t.Fatalf("Expected running, got pid %v running %v (%v)", pid, running, err)
+	}
+	waitForLog(t, d, "started")
+	if err := d.Start(ctx, "sleep", "30"); err == nil || !strings.Contains(err.Error(), "Already running") {
+		t.Fatalf("Expected already running, got %v", err)
+	}
+	if err := d.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitForProcessGone(t, pid)
+	if _, err := os.Stat(d.PidFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected pidfile removed, got %v", err)
+	}
+	// Stopping again is fine
+	if err := d.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDaemonStopKillsAfterTimeout(t *testing.T) {
+	d, cleanUp := newTestDaemon(t)
+	defer cleanUp()
+	d.StopTimeout = 300 * time.Millisecond
+	ctx := newTestContext()
+	if err := d.Start(ctx, "sh", "-c", "trap '' TERM; echo ready; while true; do sleep 0.1; done"); err != nil {
+		t.Fatal(err)
+	}
+	waitForLog(t, d, "ready")
+	pid, _ := d.Pid()
+	startTime := time.Now()
+	if err := d.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(startTime); elapsed < d.StopTimeout {
+		t.Fatalf("Expected to wait for the stop timeout before killing, took %v", elapsed)
+	}
+	waitForProcessGone(t, pid)
+	if _, err := os.Stat(d.PidFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected pidfile removed, got %v", err)
+	}
+}
+
+func TestDaemonStalePidFile(t *testing.T) {
+	// A live process the pidfile can point to that isn't the daemon
+	other := exec.Command("sleep", "30")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Wait()
+	defer other.Process.Kill()
+	otherPid := strconv.Itoa(other.Process.Pid)
+	otherStartTime, err := processStartTime(other.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		contents string
+		running  bool
+		wantErr  string
+	}{
+		{"matching start time", otherPid + "\n" + otherStartTime + "\n", true, ""},
+		{"no start time", otherPid + "\n", true, ""},
+		{"pid reused", otherPid + "\n" + otherStartTime + "0\n", false, ""},
+		{"exited", strconv.Itoa(exited.Process.Pid) + "\n", false, ""},
+		{"invalid", "nope\n", false, "Invalid pidfile"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, cleanUp := newTestDaemon(t)
+			// The pidfile isn't ours to clean up after
+			defer func() {
+				os.Remove(d.PidFile)
+				cleanUp()
+			}()
+			if err := os.MkdirAll(filepath.Dir(d.PidFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(d.PidFile, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, running, err := d.Status()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			} else if err != nil || running != test.running {
+				t.Fatalf("Expected running %v, got %v (%v)", test.running, running, err)
+			}
+			if running {
+				return
+			}
+			// A stale pidfile is removed without touching the process and doesn't stop a new start
+			ctx := newTestContext()
+			if err := d.Stop(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(d.PidFile); !os.IsNotExist(err) {
+				t.Fatalf("Expected stale pidfile removed, got %v", err)
+			}
+			if syscall.Kill(other.Process.Pid, 0) != nil {
+				t.Fatal("Expected the process reusing the pid to be left alone")
+			}
+			if err := ioutil.WriteFile(d.PidFile, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Start(ctx, "sleep", "30"); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Stop(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
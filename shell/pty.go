@@ -0,0 +1,148 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/creack/pty"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/util"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+type PtyOptions struct {
+	// Zero uses the size of our terminal (following changes) if there is one, otherwise 80x24
+	Rows uint16
+	Cols uint16
+	// Connects our terminal (in raw mode) to the command so the operator can drive it. Ignored if
+	// stdin isn't a terminal.
+	Interactive bool
+}
+
+// A pseudo-terminal for a command to run in. Terminal output (stdout and stderr together) goes to
+// the command's Stdout writers and input written to this goes to the command. Not supported on
+// Windows.
+type Pty struct {
+	*os.File
+	cmd       *exec.Cmd
+	opts      PtyOptions
+	tty       *os.File
+	output    io.Writer
+	expecters []*util.Expecter
+	copyDone  chan struct{}
+	cleanUps  []func()
+}
+
+// Opens a terminal for the command. This must be called after the command's output writers are set
+// (e.g. via WrapCommandOutput) and the command must be started with Start instead of its own.
+func NewPty(cmd *exec.Cmd, opts *PtyOptions) (*Pty, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open PTY: %v", err)
+	}
+	p := &Pty{File: ptmx, cmd: cmd, tty: tty, output: cmd.Stdout, copyDone: make(chan struct{})}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.output == nil {
+		p.output = ioutil.Discard
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	setControllingTerminal(cmd)
+	return p, nil
+}
+
+// Creates an expecter that watches the terminal output and responds as input, with the context's
+// secrets masked in its transcript. This must be called before Start and is closed by Wait.
+func (p *Pty) Expect(ctx *context.Context) *util.Expecter {
+	expecter := util.NewExpecter(p.File).WithRedactor(ctx)
+	p.output = io.MultiWriter(p.output, expecter)
+	p.expecters = append(p.expecters, expecter)
+	return expecter
+}
+
+func (p *Pty) Start() error {
+	if err := p.resize(); err != nil {
+		p.close()
+		return err
+	}
+	if err := p.cmd.Start(); err != nil {
+		p.close()
+		return fmt.Errorf("Failed to start: %v", err)
+	}
+	// Only the child needs its side now
+	p.tty.Close()
+	go func() {
+		// This ends with an error once the command and all of its children are done
+		io.Copy(p.output, p.File)
+		close(p.copyDone)
+	}()
+	stdin := int(syscall.Stdin)
+	if p.opts.Rows == 0 && p.opts.Cols == 0 && terminal.IsTerminal(stdin) {
+		p.cleanUps = append(p.cleanUps, WatchWindowSize(func() { p.resize() }))
+	}
+	if p.opts.Interactive && terminal.IsTerminal(stdin) {
+		state, err := terminal.MakeRaw(stdin)
+		if err != nil {
+			p.cmd.Process.Kill()
+			p.close()
+			return fmt.Errorf("Unable to put terminal in raw mode: %v", err)
+		}
+		p.cleanUps = append(p.cleanUps, func() { terminal.Restore(stdin, state) }, copyStdin(p.File))
+	}
+	return nil
+}
+
+// Waits for the command to finish (killing it if the context is cancelled) and its output to be
+// copied, then closes the terminal and any expecters
+func (p *Pty) Wait(ctx *context.Context) error {
+	err := waitUntil(p.cmd, ctx.Done(), 0)
+	select {
+	case <-p.copyDone:
+	case <-time.After(KillWaitDelay):
+	}
+	p.close()
+	return err
+}
+
+func (p *Pty) resize() error {
+	rows, cols := p.opts.Rows, p.opts.Cols
+	if rows == 0 && cols == 0 {
+		rows, cols = 24, 80
+		if width, height, err := terminal.GetSize(int(syscall.Stdin)); err == nil {
+			rows, cols = uint16(height), uint16(width)
+		}
+	}
+	if err := pty.Setsize(p.File, &pty.Winsize{Rows: rows, Cols: cols}); err != nil {
+		return fmt.Errorf("Unable to set PTY size: %v", err)
+	}
+	return nil
+}
+
+func (p *Pty) close() {
+	for i := len(p.cleanUps) - 1; i >= 0; i-- {
+		p.cleanUps[i]()
+	}
+	p.cleanUps = nil
+	p.tty.Close()
+	p.File.Close()
+	for _, expecter := range p.expecters {
+		expecter.Close()
+	}
+}
+
+// Runs the command in a new terminal, see PtyOptions
+func RunPty(ctx *context.Context, cmd *exec.Cmd, opts *PtyOptions) error {
+	p, err := NewPty(cmd, opts)
+	if err != nil {
+		return err
+	}
+	if err = p.Start(); err != nil {
+		return err
+	}
+	return p.Wait(ctx)
+}
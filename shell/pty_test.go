@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPty(t *testing.T) {
+	cmd := exec.Command("sh", "-c", `echo ready; read line; echo "got $line"; stty size; exit 3`)
+	out := &syncBuffer{}
+	cmd.Stdout = out
+	p, err := NewPty(cmd, &PtyOptions{Rows: 10, Cols: 40})
+	if err != nil {
+		t.Skipf("No PTY available: %v", err)
+	}
+	ctx := newTestContext()
+	expecter := p.Expect(ctx).
+		ExpectAndSend(5*time.Second, regexp.MustCompile("ready"), "hello\n").
+		ExpectAndSend(5*time.Second, regexp.MustCompile("got hello"), "")
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	err = p.Wait(ctx)
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("Expected exit code 3, got %v", err)
+	}
+	if err := expecter.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out.Bytes()), "10 40") {
+		t.Fatalf("Expected the terminal size in output, got %q", out.Bytes())
+	}
+}
@@ -0,0 +1,125 @@
+package shell
+
+import (
+	"fmt"
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/util"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The outcome of a finished command
+type Result struct {
+	CommandLine string
+	// -1 if the command never exited normally (e.g. failed to start or was killed)
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+}
+
+// A command failure along with everything known about the run
+type ResultError struct {
+	*Result
+	Err error
+}
+
+func (r *ResultError) Error() string {
+	msg := fmt.Sprintf("Command '%v' failed (exit code %v): %v", r.CommandLine, r.ExitCode, r.Err)
+	if stderr := strings.TrimSpace(string(r.Stderr)); stderr != "" {
+		msg += "\n" + stderr
+	}
+	return msg
+}
+
+type RunOptions struct {
+	// Exit codes considered success. Defaults to only 0.
+	AllowedExitCodes []int
+	// If set, the command fails if stderr matches
+	FailIfStderrMatches *regexp.Regexp
+	// Zero means no timeout
+	Timeout time.Duration
+	// If set, RunResult runs the command again on failure (except cancellation). Unused by
+	// RunCommandResult since a command can't be run twice.
+	Retry *util.RetryPolicy
+}
+
+// Runs the command capturing its output (which is still logged like any other command). The result
+// is returned even on error, which is then a *ResultError. Options may be nil.
+func RunResult(ctx *context.Context, opts *RunOptions, name string, args ...string) (*Result, error) {
+	if opts == nil || opts.Retry == nil {
+		return RunCommandResult(ctx, Command(ctx, name, args...), opts)
+	}
+	var res *Result
+	err := opts.Retry.Do(func(attempt int) (err error) {
+		res, err = RunCommandResult(ctx, Command(ctx, name, args...), opts)
+		if resErr, ok := err.(*ResultError); ok && resErr.Err == ErrCancelled {
+			return util.Permanent(err)
+		}
+		return err
+	})
+	return res, err
+}
+
+// Same as RunResult for an unstarted command
+func RunCommandResult(ctx *context.Context, cmd *exec.Cmd, opts *RunOptions) (*Result, error) {
+	if opts == nil {
+		opts = &RunOptions{}
+	}
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	AppendStdoutWriter(cmd, stdout)
+	AppendStderrWriter(cmd, stderr)
+	res := &Result{CommandLine: CommandLine(cmd), ExitCode: -1}
+	startTime := time.Now()
+	err := start(cmd, ctx.Done(), opts.Timeout)
+	exited := false
+	if err == nil {
+		exited, err = wait(cmd, ctx.Done(), opts.Timeout)
+	}
+	res.Duration = time.Since(startTime)
+	res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+	// Not safe to read if Wait is still going
+	if exited {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	// Exit errors are decided by the allowed codes, anything else is a failure regardless
+	if _, ok := err.(*exec.ExitError); ok || err == nil {
+		err = nil
+		if !exitCodeAllowed(res.ExitCode, opts.AllowedExitCodes) {
+			err = fmt.Errorf("Exit code %v not allowed", res.ExitCode)
+		} else if opts.FailIfStderrMatches != nil && opts.FailIfStderrMatches.Match(res.Stderr) {
+			err = fmt.Errorf("Stderr matched %v", opts.FailIfStderrMatches)
+		}
+	}
+	if err != nil {
+		return res, &ResultError{res, err}
+	}
+	return res, nil
+}
+
+func exitCodeAllowed(code int, allowed []int) bool {
+	if len(allowed) == 0 {
+		return code == 0
+	}
+	for _, allowedCode := range allowed {
+		if code == allowedCode {
+			return true
+		}
+	}
+	return false
+}
+
+// The args of the command joined with spaces, quoting any that need it
+func CommandLine(cmd *exec.Cmd) string {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$") {
+			arg = strconv.Quote(arg)
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, " ")
+}
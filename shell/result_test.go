@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"github.com/cretz/systrument/context"
+	"github.com/cretz/systrument/util"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      []string
+		opts     *RunOptions
+		exitCode int
+		stdout   string
+		// The whole error message if it starts with "Command", otherwise a substring of it
+		wantErr string
+	}{
+		{"success", []string{"sh", "-c", "echo out"}, nil, 0, "out\n", ""},
+		{"exit code not allowed", []string{"sh", "-c", "exit 3"}, nil, 3, "",
+			`Command 'sh -c "exit 3"' failed (exit code 3): Exit code 3 not allowed`},
+		{"exit code allowed", []string{"sh", "-c", "exit 3"}, &RunOptions{AllowedExitCodes: []int{0, 3}}, 3, "", ""},
+		{"zero not allowed", []string{"sh", "-c", "true"}, &RunOptions{AllowedExitCodes: []int{1}}, 0, "",
+			"Exit code 0 not allowed"},
+		{"stderr matches", []string{"sh", "-c", "echo out; echo 'warning: bad' >&2"},
+			&RunOptions{FailIfStderrMatches: regexp.MustCompile("bad")}, 0, "out\n",
+			"Command 'sh -c \"echo out; echo 'warning: bad' >&2\"' failed (exit code 0): Stderr matched bad\nwarning: bad"},
+		{"stderr doesn't match", []string{"sh", "-c", "echo fine >&2"},
+			&RunOptions{FailIfStderrMatches: regexp.MustCompile("bad")}, 0, "", ""},
+		{"start failure", []string{"/nonexistent/syst-command"}, nil, -1, "", "Failed to start"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := RunResult(newTestContext(), test.opts, test.cmd[0], test.cmd[1:]...)
+			if res == nil {
+				t.Fatal("Expected a result")
+			}
+			if res.ExitCode != test.exitCode || string(res.Stdout) != test.stdout {
+				t.Fatalf("Expected exit code %v and stdout %q, got %v and %q",
+					test.exitCode, test.stdout, res.ExitCode, res.Stdout)
+			}
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if _, ok := err.(*ResultError); !ok {
+				t.Fatalf("Expected *ResultError, got %#v", err)
+			}
+			if strings.HasPrefix(test.wantErr, "Command") && err.Error() != test.wantErr {
+				t.Fatalf("Expected error %q, got %q", test.wantErr, err.Error())
+			} else if !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Expected error containing %q, got %q", test.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func newTestContext() *context.Context {
+	redactor := util.NewRedactor()
+	return &context.Context{
+		Logger:   util.GoLoggerWrapper(log.New(ioutil.Discard, "", 0), false, redactor),
+		Redactor: redactor,
+	}
+}
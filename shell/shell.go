@@ -7,67 +7,191 @@ import (
 	"github.com/cretz/systrument/context"
 	"github.com/cretz/systrument/util"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sync"
+	"syscall"
 	"time"
 )
 
 var (
-	ErrTimeout = errors.New("Timed out")
+	ErrTimeout   = errors.New("Timed out")
+	ErrCancelled = errors.New("Cancelled")
 )
 
+// How long to wait for a killed command's output to close before giving up on it
+var KillWaitDelay = 5 * time.Second
+
 func Run(ctx *context.Context, name string, args ...string) error {
-	return WrapCommandOutput(ctx, exec.Command(name, args...)).Run()
+	return StartAndWait(ctx, WrapCommandOutput(ctx, exec.Command(name, args...)))
 }
 
 func RunWithTimeout(ctx *context.Context, dur time.Duration, name string, args ...string) error {
-	return StartAndWaitTimeout(WrapCommandOutput(ctx, exec.Command(name, args...)), dur)
-
+	cmd := WrapCommandOutput(ctx, exec.Command(name, args...))
+	if err := start(cmd, ctx.Done(), dur); err != nil {
+		return err
+	}
+	return waitUntil(cmd, ctx.Done(), dur)
 }
 
 func Output(ctx *context.Context, name string, args ...string) ([]byte, error) {
 	cmd := exec.Command(name, args...)
-	var b bytes.Buffer
-	cmd.Stdout = &b
-	err := WrapCommandOutput(ctx, cmd).Run()
+	b := &syncBuffer{}
+	cmd.Stdout = b
+	err := StartAndWait(ctx, WrapCommandOutput(ctx, cmd))
 	return b.Bytes(), err
 }
 
 func CombinedOutput(ctx *context.Context, name string, args ...string) ([]byte, error) {
 	cmd := exec.Command(name, args...)
-	var b bytes.Buffer
-	cmd.Stdout = &b
-	cmd.Stderr = &b
-	err := WrapCommandOutput(ctx, cmd).Run()
+	b := &syncBuffer{}
+	cmd.Stdout = b
+	cmd.Stderr = b
+	err := StartAndWait(ctx, WrapCommandOutput(ctx, cmd))
 	return b.Bytes(), err
 }
 
 func WrapCommandOutput(ctx *context.Context, cmd *exec.Cmd) *exec.Cmd {
-	// If we are verbose, we want to wrap stdout/stderr to log writes
-	if ctx.DebugEnabled() {
-		AppendStdoutWriter(cmd, util.NewDebugLogWriter("SHELL OUT:", ctx))
-		AppendStderrWriter(cmd, util.NewDebugLogWriter("SHELL ERR:", ctx))
+	// If we are verbose or streaming, we want to wrap stdout/stderr to log lines
+	if ctx.DebugEnabled() || ctx.StreamOutput {
+		AppendStdoutWriter(cmd, util.NewLineLogWriter(OutputPrefix(cmd, "OUT"), ctx, ctx.StreamOutput))
+		AppendStderrWriter(cmd, util.NewLineLogWriter(OutputPrefix(cmd, "ERR"), ctx, ctx.StreamOutput))
 	}
 	return cmd
 }
 
+// Builds the prefix for each line of command output. The stream is "OUT" or "ERR". This can be
+// replaced to customize output.
+var OutputPrefix = func(cmd *exec.Cmd, stream string) string {
+	return fmt.Sprintf("[%v] %v %v:", hostname(), filepath.Base(cmd.Path), stream)
+}
+
+var (
+	cachedHostname     string
+	cachedHostnameOnce sync.Once
+)
+
+func hostname() string {
+	cachedHostnameOnce.Do(func() {
+		if name, err := os.Hostname(); err == nil {
+			cachedHostname = name
+		} else {
+			cachedHostname = "localhost"
+		}
+	})
+	return cachedHostname
+}
+
+// Starts the command and waits for it, killing it and everything it started if the context is
+// cancelled first
+func StartAndWait(ctx *context.Context, cmd *exec.Cmd) error {
+	if err := start(cmd, ctx.Done(), 0); err != nil {
+		return err
+	}
+	return Wait(ctx, cmd)
+}
+
 func StartAndWaitTimeout(cmd *exec.Cmd, dur time.Duration) error {
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("Failed to start: %v", err)
+	if err := start(cmd, nil, dur); err != nil {
+		return err
 	}
 	return WaitTimeout(cmd, dur)
 }
 
+// Waits for a started command, killing it if the context is cancelled first
+func Wait(ctx *context.Context, cmd *exec.Cmd) error {
+	return waitUntil(cmd, ctx.Done(), 0)
+}
+
 func WaitTimeout(cmd *exec.Cmd, dur time.Duration) error {
+	return waitUntil(cmd, nil, dur)
+}
+
+// Keeps the command in our process group so it can read our terminal (e.g. a password prompt on
+// /dev/tty). On timeout or cancel only the command itself is killed, not what it started.
+func Foreground(cmd *exec.Cmd) *exec.Cmd {
+	// Commands with their own process attributes are never put in another group
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return cmd
+}
+
+// Zero duration means no timeout and a nil done channel is never closed
+func start(cmd *exec.Cmd, done <-chan struct{}, dur time.Duration) error {
+	if ownProcessGroup(cmd, done, dur) {
+		setProcessGroup(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start: %v", err)
+	}
+	return nil
+}
+
+// A command only gets its own process group when it may have to be killed along with everything it
+// started. A background group can't read our terminal, so commands given our stdin or marked as
+// foreground stay in ours, and with a terminal an interrupt already reaches our whole group.
+func ownProcessGroup(cmd *exec.Cmd, done <-chan struct{}, dur time.Duration) bool {
+	if cmd.SysProcAttr != nil || cmd.Stdin == os.Stdin {
+		return false
+	}
+	return dur > 0 || (done != nil && !hasControllingTerminal())
+}
+
+// Zero duration means no timeout and a nil done channel is never closed
+func waitUntil(cmd *exec.Cmd, done <-chan struct{}, dur time.Duration) error {
+	_, err := wait(cmd, done, dur)
+	return err
+}
+
+// Same as waitUntil but also says whether Wait finished, which is when the command's ProcessState is
+// set and its output is done being copied
+func wait(cmd *exec.Cmd, done <-chan struct{}, dur time.Duration) (bool, error) {
 	c := make(chan error, 1)
 	go func() { c <- cmd.Wait() }()
+	var timeout <-chan time.Time
+	if dur > 0 {
+		timeout = time.After(dur)
+	}
+	err := ErrTimeout
 	select {
 	case err := <-c:
-		return err
-	case <-time.After(dur):
-		cmd.Process.Kill()
-		return ErrTimeout
+		return true, err
+	case <-timeout:
+	case <-done:
+		err = ErrCancelled
 	}
+	// Killing the whole group makes sure nothing is left holding the output open, but we still
+	// don't wait on it forever
+	killProcessGroup(cmd)
+	select {
+	case <-c:
+		return true, err
+	case <-time.After(KillWaitDelay):
+		return false, err
+	}
+}
+
+// Output can still be copied in after we gave up waiting on a command, so it has to be read under
+// the same lock
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.Write(p)
+}
+
+// A copy of what has been written so far
+func (s *syncBuffer) Bytes() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]byte(nil), s.buf.Bytes()...)
 }
 
 func AppendStdoutWriter(cmd *exec.Cmd, writer io.Writer) {
@@ -86,20 +210,46 @@ func AppendStderrWriter(cmd *exec.Cmd, writer io.Writer) {
 	}
 }
 
+// Whether the command is on the PATH
+func HasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
 func Command(ctx *context.Context, name string, args ...string) *exec.Cmd {
 	return WrapCommandOutput(ctx, exec.Command(name, args...))
 }
 
 var SudoPasswordPromptMatch = regexp.MustCompile("\\[sudo\\] password for .*:")
 
+// Creates an expecter that watches stdout and stderr and responds on stdin, with the context's
+// secrets masked in its transcript. This must be called before the command is started and the
+// expecter should be closed once the command is done.
+func Expect(ctx *context.Context, cmd *exec.Cmd) (*util.Expecter, error) {
+	expecter, err := expect(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return expecter.WithRedactor(ctx), nil
+}
+
+func expect(cmd *exec.Cmd) (*util.Expecter, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	expecter := util.NewExpecter(stdin)
+	AppendStdoutWriter(cmd, expecter)
+	AppendStderrWriter(cmd, expecter)
+	return expecter, nil
+}
+
 func SudoCommand(password string, name string, args ...string) (*exec.Cmd, error) {
 	cmd := exec.Command("sudo", append([]string{"-S", name}, args...)...)
-	stdin, err := cmd.StdinPipe()
+	expecter, err := expect(cmd)
 	if err != nil {
 		return nil, err
 	}
-	sudoHandler := util.NewExpectListener(stdin, SudoPasswordPromptMatch, password+"\n")
-	AppendStdoutWriter(cmd, sudoHandler)
-	AppendStderrWriter(cmd, sudoHandler)
+	expecter.Always(&util.ExpectCase{Pattern: SudoPasswordPromptMatch, Response: password + "\n", Secret: true})
 	return cmd, nil
 }
@@ -5,6 +5,7 @@ import (
 	"syscall"
 )
 
+// Only changes the process group, see Daemon for a fully detached process
 func PutInBackgroundIfLinux(cmd *exec.Cmd) {
 	// To put this in the background, we have to change the process group
 	//	per: https://groups.google.com/forum/#!topic/golang-nuts/shST-SDqIp4.
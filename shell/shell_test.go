@@ -0,0 +1,134 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Starts a background sleep in the command's group and, if escape is set, another in its own
+// session that killing the group doesn't reach but that keeps the output open
+func groupCommand(escape bool) *exec.Cmd {
+	script := "sleep 30 & echo group $!; "
+	if escape {
+		script += "setsid sh -c 'echo escaped $$; exec sleep 30' & "
+	}
+	return exec.Command("sh", "-c", script+"wait")
+}
+
+var groupPidMatch = regexp.MustCompile(`(group|escaped) (\d+)`)
+
+// Waits for the command to report its background pids
+func backgroundPids(t *testing.T, out *syncBuffer, count int) map[string]int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pids := map[string]int{}
+		for _, match := range groupPidMatch.FindAllStringSubmatch(string(out.Bytes()), -1) {
+			pids[match[1]], _ = strconv.Atoi(match[2])
+		}
+		if len(pids) == count {
+			return pids
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Background pids not reported, output: %q", out.Bytes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Killed processes may be left as zombies when nothing reaps them, which counts as gone
+func processGone(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return true
+	}
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func waitForProcessGone(t *testing.T, pid int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Process %v still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKillProcessGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+		wantErr error
+	}{
+		{"timeout", 200 * time.Millisecond, false, ErrTimeout},
+		{"cancel", 0, true, ErrCancelled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.cancel && hasControllingTerminal() {
+				t.Skip("Commands stay in our process group with a terminal")
+			}
+			cmd := groupCommand(false)
+			out := &syncBuffer{}
+			cmd.Stdout = out
+			done := make(chan struct{})
+			if err := start(cmd, done, test.timeout); err != nil {
+				t.Fatal(err)
+			}
+			pids := backgroundPids(t, out, 1)
+			if test.cancel {
+				close(done)
+			}
+			exited, err := wait(cmd, done, test.timeout)
+			if !exited || err != test.wantErr {
+				t.Fatalf("Expected to exit with %v, got %v (exited: %v)", test.wantErr, err, exited)
+			}
+			waitForProcessGone(t, cmd.Process.Pid)
+			waitForProcessGone(t, pids["group"])
+		})
+	}
+}
+
+func TestKillWaitDelay(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("No setsid")
+	}
+	defer func(delay time.Duration) { KillWaitDelay = delay }(KillWaitDelay)
+	KillWaitDelay = 200 * time.Millisecond
+	cmd := groupCommand(true)
+	out := &syncBuffer{}
+	cmd.Stdout = out
+	if err := start(cmd, nil, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	pids := backgroundPids(t, out, 2)
+	defer syscall.Kill(pids["escaped"], syscall.SIGKILL)
+	startTime := time.Now()
+	exited, err := wait(cmd, nil, 100*time.Millisecond)
+	// The escaped process holds the output open, so Wait doesn't finish
+	if exited || err != ErrTimeout {
+		t.Fatalf("Expected to give up with timeout, got %v (exited: %v)", err, exited)
+	}
+	if elapsed := time.Since(startTime); elapsed > 2*time.Second {
+		t.Fatalf("Gave up after %v", elapsed)
+	}
+	waitForProcessGone(t, cmd.Process.Pid)
+	waitForProcessGone(t, pids["group"])
+	if processGone(pids["escaped"]) {
+		t.Fatal("Expected the process outside the group to be left alone")
+	}
+}
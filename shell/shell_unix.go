@@ -0,0 +1,161 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Puts the command in its own process group so it can be killed along with everything it starts
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// A new session also means a new process group
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr != nil && (cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Setsid) {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd.Process.Kill()
+}
+
+var (
+	controllingTerminal     bool
+	controllingTerminalOnce sync.Once
+)
+
+// Whether we have a terminal that an interrupt would come from
+func hasControllingTerminal() bool {
+	controllingTerminalOnce.Do(func() {
+		if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+			f.Close()
+			controllingTerminal = true
+		}
+	})
+	return controllingTerminal
+}
+
+// Makes the command's stdin its controlling terminal in a new session
+func setControllingTerminal(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+}
+
+// Calls the function every time our terminal is resized until the returned function is called
+func WatchWindowSize(onChange func()) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	go func() {
+		for range sigs {
+			onChange()
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
+}
+
+// Copies our stdin to the writer until the returned function is called. Reads happen on a
+// non-blocking duplicate of stdin so stopping interrupts them instead of leaving a read that eats
+// the next input meant for someone else.
+func copyStdin(w io.Writer) func() {
+	stdin := int(os.Stdin.Fd())
+	fd, err := syscall.Dup(stdin)
+	if err == nil {
+		if err = syscall.SetNonblock(fd, true); err != nil {
+			syscall.Close(fd)
+		}
+	}
+	if err != nil {
+		go io.Copy(w, os.Stdin)
+		return func() {}
+	}
+	in := os.NewFile(uintptr(fd), "stdin")
+	// Only files the runtime can poll support deadlines, others have to block like stdin itself
+	if in.SetReadDeadline(time.Time{}) != nil {
+		syscall.SetNonblock(stdin, false)
+		in.Close()
+		go io.Copy(w, os.Stdin)
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		io.Copy(w, in)
+		close(done)
+	}()
+	return func() {
+		in.SetReadDeadline(time.Now())
+		// A write to the command can still be blocked
+		select {
+		case <-done:
+		case <-time.After(KillWaitDelay):
+		}
+		in.Close()
+		// The duplicate shares the non-blocking flag with stdin
+		syscall.SetNonblock(stdin, false)
+	}
+}
+
+// A new session has no controlling terminal and isn't sent our terminal's signals
+func detach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// The pid is expected to lead its group. SIGTERM unless killing.
+func stopProcessGroup(pid int, kill bool) error {
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	err := syscall.Kill(-pid, sig)
+	// Not a group leader after all
+	if err == syscall.ESRCH {
+		err = syscall.Kill(pid, sig)
+	}
+	return err
+}
+
+// Something that differs between processes that reuse the pid. Linux has the start time in /proc,
+// elsewhere ps has it.
+func processStartTime(pid int) (string, error) {
+	if byts, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat"); err == nil {
+		// The command name is in parens and can have spaces, the start time is the 20th field after
+		fields := strings.Fields(string(byts[bytes.LastIndexByte(byts, ')')+1:]))
+		if len(fields) < 20 {
+			return "", fmt.Errorf("Unrecognized stat for pid %v", pid)
+		}
+		return fields[19], nil
+	}
+	byts, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get start time of pid %v: %v", pid, err)
+	}
+	return strings.Join(strings.Fields(string(byts)), " "), nil
+}
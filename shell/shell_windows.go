@@ -1,7 +1,79 @@
 package shell
 
-import "os/exec"
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
 
+// Only changes the process group, see Daemon for a fully detached process
 func PutInBackgroundIfLinux(cmd *exec.Cmd) {
 	// No-op
 }
+
+func setProcessGroup(cmd *exec.Cmd) {
+	// No-op
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func hasControllingTerminal() bool {
+	return false
+}
+
+func setControllingTerminal(cmd *exec.Cmd) {
+	// No-op
+}
+
+func WatchWindowSize(onChange func()) func() {
+	// No-op
+	return func() {}
+}
+
+func copyStdin(w io.Writer) func() {
+	go io.Copy(w, os.Stdin)
+	return func() {}
+}
+
+func detach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+func processAlive(pid int) bool {
+	// This fails if there is no such process
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// There is no graceful signal, so this always kills
+func stopProcessGroup(pid int, kill bool) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+func processStartTime(pid int) (string, error) {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(creation.Nanoseconds(), 10), nil
+}
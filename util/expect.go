@@ -0,0 +1,293 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrExpectOutputEnded = errors.New("Output ended")
+
+// The most unmatched output kept for matching, older output is dropped
+var MaxExpectBuffer = 64 * 1024
+
+// The most output kept for the transcript, older output is dropped
+var MaxExpectTranscript = 64 * 1024
+
+// A pattern to look for in output and what to write back when it's seen
+type ExpectCase struct {
+	Pattern *regexp.Regexp
+	// May be empty to just wait for the pattern
+	Response string
+	// Keeps the response out of the transcript
+	Secret bool
+}
+
+// Waits for any one of the cases
+type ExpectStep struct {
+	Cases []*ExpectCase
+	// Zero means no timeout. The time starts when the previous step matches (or for the first step,
+	// at the first write or wait).
+	Timeout time.Duration
+	// Index of the case that matched, -1 until then
+	Matched int
+}
+
+type ExpectError struct {
+	// Zero-based index of the step that was not matched
+	Step     int
+	Patterns []string
+	Err      error
+}
+
+func (e *ExpectError) Error() string {
+	return fmt.Sprintf("%v waiting for step %v (%v)", e.Err, e.Step+1, strings.Join(e.Patterns, " or "))
+}
+
+// Buffers output across writes and answers patterns, both in order via steps and any time they
+// appear via always-cases. This is a writer itself meant to receive the output (e.g. stdout and
+// stderr of a command or SSH session) and writes responses to another writer (e.g. the stdin).
+type Expecter struct {
+	writeTo    io.Writer
+	always     []*ExpectCase
+	steps      []*ExpectStep
+	redactor   Redactor
+	lock       sync.Mutex
+	buf        []byte
+	transcript bytes.Buffer
+	step       int
+	started    bool
+	timer      *time.Timer
+	done       chan struct{}
+	err        error
+	// Responses not yet written, in order, and whether they are being written
+	responses []string
+	writing   bool
+}
+
+func NewExpecter(writeTo io.Writer) *Expecter {
+	return &Expecter{writeTo: writeTo, done: make(chan struct{})}
+}
+
+// Adds cases that are answered every time they appear regardless of the current step (e.g. a
+// password prompt)
+func (e *Expecter) Always(cases ...*ExpectCase) *Expecter {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.always = append(e.always, cases...)
+	return e
+}
+
+// Masks secrets in the transcript with the redactor (e.g. the context's) in addition to the
+// responses of secret cases
+func (e *Expecter) WithRedactor(redactor Redactor) *Expecter {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.redactor = redactor
+	return e
+}
+
+// Adds a step waiting for any one of the given cases after the previous steps
+func (e *Expecter) Expect(timeout time.Duration, cases ...*ExpectCase) *Expecter {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.steps = append(e.steps, &ExpectStep{Cases: cases, Timeout: timeout, Matched: -1})
+	return e
+}
+
+// Shortcut for a step with a single case
+func (e *Expecter) ExpectAndSend(timeout time.Duration, pattern *regexp.Regexp, response string) *Expecter {
+	return e.Expect(timeout, &ExpectCase{Pattern: pattern, Response: response})
+}
+
+func (e *Expecter) Steps() []*ExpectStep {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.steps
+}
+
+func (e *Expecter) Write(p []byte) (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.start()
+	e.transcript.Write(p)
+	e.buf = append(e.buf, p...)
+	e.match()
+	if len(e.buf) > MaxExpectBuffer {
+		e.buf = e.buf[len(e.buf)-MaxExpectBuffer:]
+	}
+	if e.transcript.Len() > MaxExpectTranscript {
+		e.transcript.Next(e.transcript.Len() - MaxExpectTranscript)
+		// The first line may be the end of a secret the redactor can no longer recognize
+		if i := bytes.IndexByte(e.transcript.Bytes(), '\n'); i >= 0 {
+			e.transcript.Next(i + 1)
+		}
+	}
+	return len(p), nil
+}
+
+// Called when there will be no more output. Steps not yet matched fail.
+func (e *Expecter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.fail(ErrExpectOutputEnded)
+	return nil
+}
+
+// Waits until all steps match or one fails. Without steps, this waits for Close.
+func (e *Expecter) Wait() error {
+	e.lock.Lock()
+	e.start()
+	e.lock.Unlock()
+	<-e.done
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.err
+}
+
+// The output seen (at most MaxExpectTranscript of the latest) with the responses marked inline
+func (e *Expecter) Transcript() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.redactor != nil {
+		return e.redactor.Redact(e.transcript.String())
+	}
+	return e.transcript.String()
+}
+
+// Must be called with lock held
+func (e *Expecter) start() {
+	if !e.started {
+		e.started = true
+		e.startStep()
+	}
+}
+
+// Must be called with lock held
+func (e *Expecter) startStep() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	if e.step < len(e.steps) && e.steps[e.step].Timeout > 0 {
+		step := e.step
+		e.timer = time.AfterFunc(e.steps[step].Timeout, func() {
+			e.lock.Lock()
+			defer e.lock.Unlock()
+			if e.step == step {
+				e.fail(ErrTimeout)
+			}
+		})
+	} else if e.step >= len(e.steps) && len(e.steps) > 0 {
+		e.finish(nil)
+	}
+}
+
+// Must be called with lock held
+func (e *Expecter) match() {
+	for {
+		// Whichever case matches earliest in the buffer wins
+		var matched *ExpectCase
+		var loc []int
+		stepMatched := -1
+		check := func(c *ExpectCase) bool {
+			if l := c.Pattern.FindIndex(e.buf); l != nil && (loc == nil || l[0] < loc[0]) {
+				matched, loc = c, l
+				return true
+			}
+			return false
+		}
+		if e.err == nil && e.step < len(e.steps) {
+			for i, c := range e.steps[e.step].Cases {
+				if check(c) {
+					stepMatched = i
+				}
+			}
+		}
+		for _, c := range e.always {
+			if check(c) {
+				stepMatched = -1
+			}
+		}
+		if matched == nil {
+			return
+		}
+		e.buf = e.buf[loc[1]:]
+		if matched.Response != "" {
+			if matched.Secret {
+				fmt.Fprintf(&e.transcript, "[sent %v]", RedactedText)
+			} else {
+				fmt.Fprintf(&e.transcript, "[sent %q]", matched.Response)
+			}
+			e.send(matched.Response)
+		}
+		if stepMatched >= 0 {
+			e.steps[e.step].Matched = stepMatched
+			e.step++
+			e.startStep()
+		}
+	}
+}
+
+// Queues the response to be written in order without the lock held, since writing may block until
+// the other side reads, which it may not do until its output is written here. Must be called with
+// lock held.
+func (e *Expecter) send(response string) {
+	e.responses = append(e.responses, response)
+	if !e.writing {
+		e.writing = true
+		go e.writeResponses()
+	}
+}
+
+func (e *Expecter) writeResponses() {
+	for {
+		e.lock.Lock()
+		if len(e.responses) == 0 {
+			e.writing = false
+			e.lock.Unlock()
+			return
+		}
+		response := e.responses[0]
+		e.responses = e.responses[1:]
+		e.lock.Unlock()
+		if _, err := io.WriteString(e.writeTo, response); err != nil {
+			e.lock.Lock()
+			e.fail(fmt.Errorf("Unable to write after seeing expected output: %v", err))
+			e.responses, e.writing = nil, false
+			e.lock.Unlock()
+			return
+		}
+	}
+}
+
+// Must be called with lock held
+func (e *Expecter) fail(err error) {
+	if e.step < len(e.steps) {
+		patterns := []string{}
+		for _, c := range e.steps[e.step].Cases {
+			patterns = append(patterns, c.Pattern.String())
+		}
+		e.finish(&ExpectError{Step: e.step, Patterns: patterns, Err: err})
+	} else {
+		e.finish(nil)
+	}
+}
+
+// Must be called with lock held
+func (e *Expecter) finish(err error) {
+	select {
+	case <-e.done:
+	default:
+		e.err = err
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		close(e.done)
+	}
+}
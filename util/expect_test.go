@@ -0,0 +1,153 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.buf.String()
+}
+
+func TestExpecter(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(e *Expecter)
+		writes []string
+		// Whether to close after the writes
+		close    bool
+		sent     string
+		matched  []int
+		wantStep int
+		wantErr  error
+	}{
+		{"steps across writes", func(e *Expecter) {
+			e.ExpectAndSend(0, regexp.MustCompile("user:"), "me\n").ExpectAndSend(0, regexp.MustCompile("pass:"), "pw\n")
+		}, []string{"us", "er: pa", "ss:"}, false, "me\npw\n", []int{0, 0}, -1, nil},
+		{"steps in order", func(e *Expecter) {
+			e.ExpectAndSend(0, regexp.MustCompile("one"), "1").ExpectAndSend(0, regexp.MustCompile("two"), "2")
+		}, []string{"two one"}, true, "1", []int{0, -1}, 1, ErrExpectOutputEnded},
+		{"earliest case wins", func(e *Expecter) {
+			e.Expect(0, &ExpectCase{Pattern: regexp.MustCompile("b"), Response: "b"},
+				&ExpectCase{Pattern: regexp.MustCompile("a"), Response: "a"})
+		}, []string{"ab"}, false, "a", []int{1}, -1, nil},
+		{"always answered", func(e *Expecter) {
+			e.Always(&ExpectCase{Pattern: regexp.MustCompile("more\\?"), Response: "y"}).
+				ExpectAndSend(0, regexp.MustCompile("done"), "")
+		}, []string{"more? more?", " done"}, false, "yy", []int{0}, -1, nil},
+		{"output ended", func(e *Expecter) {
+			e.ExpectAndSend(0, regexp.MustCompile("never"), "x")
+		}, []string{"something"}, true, "", []int{-1}, 0, ErrExpectOutputEnded},
+		{"timeout", func(e *Expecter) {
+			e.ExpectAndSend(10*time.Millisecond, regexp.MustCompile("never"), "x")
+		}, nil, false, "", []int{-1}, 0, ErrTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sent := &lockedBuffer{}
+			e := NewExpecter(sent)
+			test.setup(e)
+			for _, write := range test.writes {
+				if _, err := e.Write([]byte(write)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.close {
+				e.Close()
+			}
+			err := e.Wait()
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if test.wantErr != nil {
+				expectErr, ok := err.(*ExpectError)
+				if !ok || expectErr.Err != test.wantErr || expectErr.Step != test.wantStep {
+					t.Fatalf("Expected %v at step %v, got %v", test.wantErr, test.wantStep, err)
+				}
+			}
+			for i, step := range e.Steps() {
+				if step.Matched != test.matched[i] {
+					t.Fatalf("Expected step %v to match case %v, got %v", i, test.matched[i], step.Matched)
+				}
+			}
+			// Responses are written in the background
+			deadline := time.Now().Add(time.Second)
+			for sent.String() != test.sent && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if sent.String() != test.sent {
+				t.Fatalf("Expected to send %q, sent %q", test.sent, sent.String())
+			}
+		})
+	}
+}
+
+func TestExpecterWriteDoesNotWaitForResponse(t *testing.T) {
+	r, w := io.Pipe()
+	e := NewExpecter(w).Always(&ExpectCase{Pattern: regexp.MustCompile("\\?"), Response: "y"})
+	written := make(chan struct{})
+	go func() {
+		// Nothing reads the responses yet, so these would block if written in place
+		e.Write([]byte("one?"))
+		e.Write([]byte("two?"))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on the response")
+	}
+	byts := make([]byte, 2)
+	if _, err := io.ReadFull(r, byts); err != nil || string(byts) != "yy" {
+		t.Fatalf("Expected responses yy, got %q (%v)", byts, err)
+	}
+	e.Close()
+	w.Close()
+	ioutil.ReadAll(r)
+}
+
+func TestExpecterTranscriptRedacted(t *testing.T) {
+	redactor := NewRedactor()
+	redactor.AddSecrets("hunter22")
+	e := NewExpecter(ioutil.Discard).WithRedactor(redactor).
+		Always(&ExpectCase{Pattern: regexp.MustCompile("pass:"), Response: "other-secret", Secret: true}).
+		ExpectAndSend(0, regexp.MustCompile("token:"), "hunter22")
+	for _, write := range []string{"pass: ", "token: ", " echo hunter22"} {
+		e.Write([]byte(write))
+	}
+	expected := `pass: [sent ******]token: [sent "******"] echo ******`
+	if actual := e.Transcript(); actual != expected {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+}
+
+func TestExpecterTranscriptCapped(t *testing.T) {
+	defer func(max int) { MaxExpectTranscript = max }(MaxExpectTranscript)
+	MaxExpectTranscript = 10
+	redactor := NewRedactor()
+	redactor.AddSecrets("hunter22")
+	e := NewExpecter(ioutil.Discard).WithRedactor(redactor)
+	for _, write := range []string{"first line\n", "pass hunter22\nlast", " line"} {
+		e.Write([]byte(write))
+	}
+	if actual := e.Transcript(); actual != "last line" {
+		t.Fatalf("Expected only the tail, got %q", actual)
+	}
+}
@@ -3,8 +3,10 @@ package util
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"time"
 )
@@ -51,23 +53,45 @@ func ReaderWaitFor(r io.Reader, re *regexp.Regexp, duration time.Duration) ([]by
 	}
 }
 
-// Write to something when this writer matches some regex. This is a writer itself.
+// Write to something every time this writer sees some regex, even across writes. This is a writer
+// itself. The response is treated as secret.
 type ExpectListener struct {
-	writeTo io.Writer
-	regex   *regexp.Regexp
-	toWrite string
+	*Expecter
 }
 
 func NewExpectListener(writeTo io.Writer, regex *regexp.Regexp, toWrite string) *ExpectListener {
-	return &ExpectListener{writeTo, regex, toWrite}
+	return &ExpectListener{NewExpecter(writeTo).Always(&ExpectCase{Pattern: regex, Response: toWrite, Secret: true})}
 }
 
-func (e *ExpectListener) Write(p []byte) (int, error) {
-	// TODO: use a scanner or something
-	if e.regex.Match(p) {
-		if _, err := e.writeTo.Write([]byte(e.toWrite)); err != nil {
-			return len(p), fmt.Errorf("Unable to write after seeing expected input: %v", err)
+// Writes the file, creating its dir, unless it already has the contents and mode. It's written aside
+// and renamed so readers never see a partial file. Returns whether anything changed.
+func WriteFileIfChanged(path string, byts []byte, mode os.FileMode) (bool, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm() == mode.Perm() {
+		if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, byts) {
+			return false, nil
 		}
 	}
-	return len(p), nil
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	tempPath := path + ".syst-tmp"
+	// Created with the mode from the start so the contents are never readable by others
+	f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err == nil {
+		// Chmod too since the umask applies to OpenFile and the file may have been left before
+		if err = f.Chmod(mode); err == nil {
+			_, err = f.Write(byts)
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return false, err
+	}
+	return true, nil
 }
@@ -1,5 +1,12 @@
 package util
 
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
 type Logger interface {
 	DebugEnabled() bool
 	Infof(string, ...interface{})
@@ -8,6 +15,7 @@ type Logger interface {
 
 type goLoggerWrapper struct {
 	GoLogger
+	Redactor
 	debug bool
 }
 
@@ -15,8 +23,13 @@ type GoLogger interface {
 	Printf(string, ...interface{})
 }
 
-func GoLoggerWrapper(goLog GoLogger, debug bool) Logger {
-	return &goLoggerWrapper{goLog, debug}
+// Everything logged is masked by the redactor, which is also implemented by the result. A new one is
+// used if it is nil.
+func GoLoggerWrapper(goLog GoLogger, debug bool, redactor Redactor) Logger {
+	if redactor == nil {
+		redactor = NewRedactor()
+	}
+	return &goLoggerWrapper{goLog, redactor, debug}
 }
 
 func (g *goLoggerWrapper) DebugEnabled() bool {
@@ -25,26 +38,129 @@ func (g *goLoggerWrapper) DebugEnabled() bool {
 
 func (g *goLoggerWrapper) Infof(format string, v ...interface{}) {
 	// TODO: send this back to remote please...
-	g.GoLogger.Printf(format, v...)
+	g.GoLogger.Printf("%v", g.Redact(fmt.Sprintf(format, v...)))
 }
 
 func (g *goLoggerWrapper) Debugf(format string, v ...interface{}) {
 	// TODO: send this back to remote please...
 	if g.debug {
-		g.GoLogger.Printf(format, v...)
+		g.GoLogger.Printf("%v", g.Redact(fmt.Sprintf(format, v...)))
 	}
 }
 
+// Logs what is written to it at debug level a line at a time, so secrets split across writes are
+// still masked
 type DebugLogWriter struct {
+	*LineLogWriter
+}
+
+func NewDebugLogWriter(prefix string, logger Logger) *DebugLogWriter {
+	return &DebugLogWriter{NewLineLogWriter(prefix, logger, false)}
+}
+
+// How long a partial line waits for the rest of it before being logged anyway
+var LinePartialFlushDelay = 200 * time.Millisecond
+
+// Logs each complete line written to it with a prefix, at info level if requested or debug level
+// otherwise. Lines from different writers never interleave mid-line. If the logger is also a
+// Redactor, partial lines are logged without any end that could be the start of a secret. Safe for
+// concurrent use.
+type LineLogWriter struct {
 	prefix string
 	logger Logger
+	info   bool
+	// If set, only lines it returns true for are logged
+	Filter func(line string) bool
+	lock   sync.Mutex
+	buf    []byte
+	timer  *time.Timer
 }
 
-func NewDebugLogWriter(prefix string, logger Logger) *DebugLogWriter {
-	return &DebugLogWriter{prefix, logger}
+func NewLineLogWriter(prefix string, logger Logger, info bool) *LineLogWriter {
+	return &LineLogWriter{prefix: prefix, logger: logger, info: info}
 }
 
-func (d *DebugLogWriter) Write(p []byte) (n int, err error) {
-	d.logger.Debugf("%v %v", d.prefix, string(p))
+func (l *LineLogWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		index := bytes.IndexByte(l.buf, '\n')
+		if index < 0 {
+			break
+		}
+		l.logLine(string(bytes.TrimSuffix(l.buf[:index], []byte("\r"))))
+		l.buf = l.buf[index+1:]
+	}
+	// Things like prompts never end their line, so we can't wait forever
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	if len(l.buf) > 0 {
+		l.timer = time.AfterFunc(LinePartialFlushDelay, l.flushPartial)
+		pendingLineLogWriters.Store(l, true)
+	} else {
+		pendingLineLogWriters.Delete(l)
+	}
 	return len(p), nil
 }
+
+// Logs any partial line
+func (l *LineLogWriter) Flush() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.buf) > 0 {
+		l.logLine(string(l.buf))
+		l.buf = nil
+	}
+	pendingLineLogWriters.Delete(l)
+}
+
+func (l *LineLogWriter) flushPartial() {
+	redactor, ok := l.logger.(Redactor)
+	if !ok {
+		l.Flush()
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.buf) == 0 {
+		return
+	}
+	redacted, rest := redactor.RedactPartial(string(l.buf))
+	if redacted != "" {
+		l.logLine(redacted)
+	}
+	// The rest waits for more to be written or the final flush
+	l.buf = []byte(rest)
+	if len(l.buf) == 0 {
+		pendingLineLogWriters.Delete(l)
+	}
+}
+
+// Writers with partial lines not yet logged
+var pendingLineLogWriters sync.Map
+
+// Logs all partial lines not yet logged. This should be called before exiting.
+func FlushLineLogWriters() {
+	pendingLineLogWriters.Range(func(key, _ interface{}) bool {
+		key.(*LineLogWriter).Flush()
+		return true
+	})
+}
+
+func (l *LineLogWriter) Close() error {
+	l.Flush()
+	return nil
+}
+
+func (l *LineLogWriter) logLine(line string) {
+	if l.Filter != nil && !l.Filter(line) {
+		return
+	}
+	if l.info {
+		l.logger.Infof("%v %v", l.prefix, line)
+	} else {
+		l.logger.Debugf("%v %v", l.prefix, line)
+	}
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const RedactedText = "******"
+
+// Secrets shorter than this are ignored since masking them would mangle ordinary output
+const MinSecretLength = 4
+
+// Masks registered secret values in text
+type Redactor interface {
+	// Registers values that will be masked in everything redacted from here on
+	AddSecrets(...string)
+	Redact(string) string
+	// Redacts text that may be continued later. The end of it that could be the start of a secret is
+	// not redacted and returned separately so it can be redacted with what follows.
+	RedactPartial(string) (redacted string, rest string)
+}
+
+// Safe for concurrent use
+type secretRedactor struct {
+	lock     sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+func NewRedactor() Redactor {
+	return &secretRedactor{secrets: map[string]bool{}}
+}
+
+// Each line of a multi-line secret is also a secret since output is often logged a line at a time
+func (r *secretRedactor) AddSecrets(secrets ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	changed := false
+	add := func(secret string) {
+		if len(secret) >= MinSecretLength && !r.secrets[secret] {
+			r.secrets[secret] = true
+			changed = true
+		}
+	}
+	for _, secret := range secrets {
+		add(secret)
+		if strings.Contains(secret, "\n") {
+			for _, line := range strings.Split(secret, "\n") {
+				add(strings.TrimSpace(line))
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+	// Longest first so a secret containing another secret is masked whole
+	sorted := make([]string, 0, len(r.secrets))
+	for secret := range r.secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, RedactedText)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+func (r *secretRedactor) Redact(str string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.replacer == nil {
+		return str
+	}
+	return r.replacer.Replace(str)
+}
+
+func (r *secretRedactor) RedactPartial(str string) (string, string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.replacer == nil {
+		return str, ""
+	}
+	// Whole secrets are already masked, so what's held back is still the original text
+	redacted := r.replacer.Replace(str)
+	held := 0
+	for secret := range r.secrets {
+		for n := len(secret) - 1; n > held; n-- {
+			if n <= len(redacted) && strings.HasSuffix(redacted, secret[:n]) {
+				held = n
+				break
+			}
+		}
+	}
+	return redacted[:len(redacted)-held], redacted[len(redacted)-held:]
+}
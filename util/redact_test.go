@@ -0,0 +1,110 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		secrets  []string
+		str      string
+		expected string
+	}{
+		{"no secrets", nil, "pass secret1", "pass secret1"},
+		{"secret", []string{"secret1"}, "pass secret1 again secret1", "pass ****** again ******"},
+		{"longest first", []string{"secret", "secret-longer"}, "a secret-longer b secret", "a ****** b ******"},
+		{"too short", []string{"abc", ""}, "abc def", "abc def"},
+		{"each line of multi-line", []string{"line-one\r\nline-two\n"}, "x line-two y", "x ****** y"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRedactor()
+			r.AddSecrets(test.secrets...)
+			if actual := r.Redact(test.str); actual != test.expected {
+				t.Fatalf("Expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRedactPartial(t *testing.T) {
+	tests := []struct {
+		name     string
+		str      string
+		redacted string
+		rest     string
+	}{
+		{"no secret", "password: ", "password: ", ""},
+		{"whole secret", "password: hunter22", "password: ******", ""},
+		{"start of secret", "password: hunt", "password: ", "hunt"},
+		{"whole then start", "hunter22 hunter", "****** ", "hunter"},
+		{"start of other secret", "x swordf", "x ", "swordf"},
+	}
+	r := NewRedactor()
+	r.AddSecrets("hunter22", "swordfish")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted, rest := r.RedactPartial(test.str)
+			if redacted != test.redacted || rest != test.rest {
+				t.Fatalf("Expected %q and %q, got %q and %q", test.redacted, test.rest, redacted, rest)
+			}
+		})
+	}
+}
+
+type recordingLogger struct {
+	Redactor
+	lock  sync.Mutex
+	lines []string
+}
+
+func (r *recordingLogger) DebugEnabled() bool { return true }
+
+func (r *recordingLogger) Infof(format string, v ...interface{}) { r.Debugf(format, v...) }
+
+func (r *recordingLogger) Debugf(format string, v ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lines = append(r.lines, r.Redact(fmt.Sprintf(format, v...)))
+}
+
+func TestLineLogWriterRedactsAcrossWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		// Whether to wait for partial lines to be flushed after each write
+		wait     bool
+		expected []string
+	}{
+		{"split in line", []string{"pass hun", "ter22 ok\n"}, false, []string{"OUT: pass ****** ok"}},
+		{"split across partial flush", []string{"pass hun", "ter22 ok"}, true,
+			[]string{"OUT: pass ", "OUT: ****** ok"}},
+		{"held until close", []string{"pass hunter2"}, true, []string{"OUT: pass ", "OUT: hunter2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := &recordingLogger{Redactor: NewRedactor()}
+			logger.AddSecrets("hunter22")
+			w := NewLineLogWriter("OUT:", logger, false)
+			for _, write := range test.writes {
+				if _, err := w.Write([]byte(write)); err != nil {
+					t.Fatal(err)
+				}
+				if test.wait {
+					// What the timer does
+					w.flushPartial()
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(logger.lines, test.expected) {
+				t.Fatalf("Expected %q, got %q", test.expected, logger.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var ErrRetryCancelled = errors.New("Retry cancelled")
+
+// Retries a function with exponential backoff. The zero value tries once.
+type RetryPolicy struct {
+	// Total tries including the first, less than 1 is treated as 1
+	Attempts int
+	// Delay before the first retry
+	InitialDelay time.Duration
+	// Zero means no max
+	MaxDelay time.Duration
+	// Delay growth per retry, less than 1 is treated as 2
+	Multiplier float64
+	// Fraction of each delay that is randomized, between 0 and 1
+	Jitter float64
+	// Nil means all errors are retryable
+	Retryable func(error) bool
+	// When closed, no more attempts are made
+	Cancel <-chan struct{}
+	// Called before waiting to retry
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:     3,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// Wraps an error so it is never retried regardless of the policy
+type PermanentError struct {
+	Err error
+}
+
+func (p *PermanentError) Error() string {
+	return p.Err.Error()
+}
+
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{err}
+}
+
+// Calls the function until it succeeds, the error isn't retryable, the attempts are exhausted, or
+// the policy is cancelled. Attempts start at 1. The last error is returned (unwrapped if
+// permanent) or ErrRetryCancelled if cancelled while waiting.
+func (r *RetryPolicy) Do(fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if permanent, ok := err.(*PermanentError); ok {
+			return permanent.Err
+		}
+		if attempt >= r.Attempts || (r.Retryable != nil && !r.Retryable(err)) {
+			return err
+		}
+		delay := r.Delay(attempt)
+		if r.OnRetry != nil {
+			r.OnRetry(attempt, err, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Cancel:
+			return ErrRetryCancelled
+		}
+	}
+}
+
+// The delay after the given failed attempt, jitter included
+func (r *RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := r.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(r.InitialDelay)
+	for i := 1; i < attempt && (r.MaxDelay == 0 || delay < float64(r.MaxDelay)); i++ {
+		delay *= multiplier
+	}
+	if r.MaxDelay > 0 && delay > float64(r.MaxDelay) {
+		delay = float64(r.MaxDelay)
+	}
+	if r.Jitter > 0 {
+		delay += delay * r.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(delay)
+}
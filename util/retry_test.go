@@ -0,0 +1,79 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		expected time.Duration
+	}{
+		{"first", RetryPolicy{InitialDelay: time.Second}, 1, time.Second},
+		{"default multiplier", RetryPolicy{InitialDelay: time.Second}, 3, 4 * time.Second},
+		{"multiplier", RetryPolicy{InitialDelay: time.Second, Multiplier: 3}, 3, 9 * time.Second},
+		{"capped", RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, 10, 5 * time.Second},
+		{"capped without overflow", RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}, 1000, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.policy.Delay(test.attempt); actual != test.expected {
+				t.Fatalf("Expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(1); delay < 800*time.Millisecond || delay > 1200*time.Millisecond {
+			t.Fatalf("Delay %v outside of jitter", delay)
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error
+		attempts  int
+		expected  error
+		cancelled bool
+	}{
+		{"success", RetryPolicy{Attempts: 3}, []error{nil}, 1, nil, false},
+		{"succeeds on retry", RetryPolicy{Attempts: 3}, []error{errFailed, nil}, 2, nil, false},
+		{"exhausted", RetryPolicy{Attempts: 3}, []error{errFailed, errFailed, errFailed}, 3, errFailed, false},
+		{"zero value tries once", RetryPolicy{}, []error{errFailed}, 1, errFailed, false},
+		{"permanent unwrapped", RetryPolicy{Attempts: 3}, []error{Permanent(errFailed)}, 1, errFailed, false},
+		{"not retryable", RetryPolicy{Attempts: 3, Retryable: func(error) bool { return false }},
+			[]error{errFailed}, 1, errFailed, false},
+		{"cancelled", RetryPolicy{Attempts: 3, InitialDelay: time.Hour}, []error{errFailed}, 1, ErrRetryCancelled, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.cancelled {
+				cancel := make(chan struct{})
+				close(cancel)
+				test.policy.Cancel = cancel
+			}
+			attempts := 0
+			err := test.policy.Do(func(attempt int) error {
+				attempts++
+				if attempt != attempts {
+					t.Fatalf("Expected attempt %v, got %v", attempts, attempt)
+				}
+				return test.errs[attempt-1]
+			})
+			if err != test.expected || attempts != test.attempts {
+				t.Fatalf("Expected %v after %v attempts, got %v after %v", test.expected, test.attempts, err, attempts)
+			}
+		})
+	}
+}
@@ -68,12 +68,18 @@ func ExtractTarballFile(filename string, target string) error {
 	return ExtractTarball(f, target)
 }
 
+// Entries, including link targets, can't leave the target dir and nothing is written through an
+// existing link that leads outside of it
 func ExtractTarball(file *os.File, target string) error {
 	gr, err := gzip.NewReader(file)
 	if err != nil {
 		return fmt.Errorf("Unable to read gzip: %v", err)
 	}
 	defer gr.Close()
+	// Resolved so the resolved parents of entries can be compared against it
+	if target, err = filepath.EvalSymlinks(target); err != nil {
+		return err
+	}
 	tr := tar.NewReader(gr)
 	for {
 		header, err := tr.Next()
@@ -83,12 +89,58 @@ func ExtractTarball(file *os.File, target string) error {
 			return fmt.Errorf("Error reading tar: %v", err)
 		}
 		path := filepath.Join(target, header.Name)
+		if !insideDir(target, path) {
+			return fmt.Errorf("Tar entry %v is outside of target", header.Name)
+		} else if err := checkParents(target, path); err != nil {
+			return fmt.Errorf("Tar entry %v: %v", header.Name, err)
+		}
 		info := header.FileInfo()
 		if info.IsDir() {
 			if err := os.MkdirAll(path, info.Mode()); err != nil {
 				return err
 			}
-		} else {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// Replaced instead of written through
+		if existing, err := os.Lstat(path); err == nil && !existing.IsDir() {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			// Relative to where the link really is
+			dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(header.Linkname) || !insideDir(target, filepath.Join(dir, header.Linkname)) {
+				return fmt.Errorf("Tar entry %v links to %v outside of target", header.Name, header.Linkname)
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// Hard link names are from the root of the archive
+			linkPath := filepath.Join(target, header.Linkname)
+			if !insideDir(target, linkPath) {
+				return fmt.Errorf("Tar entry %v links to %v outside of target", header.Name, header.Linkname)
+			} else if err := checkParents(target, linkPath); err != nil {
+				return fmt.Errorf("Tar entry %v: %v", header.Name, err)
+			}
+			// Some platforms follow symlinks when hard linking
+			if linkInfo, err := os.Lstat(linkPath); err != nil {
+				return err
+			} else if !linkInfo.Mode().IsRegular() {
+				return fmt.Errorf("Tar entry %v must link to a regular file", header.Name)
+			}
+			if err := os.Link(linkPath, path); err != nil {
+				return err
+			}
+		default:
 			childFile, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
 			if err != nil {
 				return err
@@ -102,3 +154,22 @@ func ExtractTarball(file *os.File, target string) error {
 	}
 	return nil
 }
+
+// Whether the path is the dir or beneath it. Both must be clean.
+func insideDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Errors if the deepest existing parent of the path resolves outside of the dir
+func checkParents(dir string, path string) error {
+	for parent := filepath.Dir(path); parent != dir && insideDir(dir, parent); parent = filepath.Dir(parent) {
+		if resolved, err := filepath.EvalSymlinks(parent); err == nil {
+			if !insideDir(dir, resolved) {
+				return fmt.Errorf("%v leads outside of target", parent)
+			}
+			return nil
+		}
+	}
+	return nil
+}
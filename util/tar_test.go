@@ -0,0 +1,85 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractTarballStaysInTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		wantErr bool
+	}{
+		{"regular", []tar.Header{{Name: "a/b.txt", Typeflag: tar.TypeReg}}, false},
+		{"dot-dot prefixed name", []tar.Header{{Name: "..foo", Typeflag: tar.TypeReg}}, false},
+		{"parent name", []tar.Header{{Name: "../escape.txt", Typeflag: tar.TypeReg}}, true},
+		{"inner symlink", []tar.Header{
+			{Name: "a/b.txt", Typeflag: tar.TypeReg},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a/b.txt"},
+		}, false},
+		{"absolute symlink", []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}, true},
+		{"parent symlink", []tar.Header{{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../.."}}, true},
+		{"write through symlink parent", []tar.Header{
+			{Name: "self", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "self/link", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "self/link/escape.txt", Typeflag: tar.TypeReg},
+		}, true},
+		{"inner hard link", []tar.Header{
+			{Name: "a.txt", Typeflag: tar.TypeReg},
+			{Name: "b.txt", Typeflag: tar.TypeLink, Linkname: "a.txt"},
+		}, false},
+		{"outer hard link", []tar.Header{{Name: "b.txt", Typeflag: tar.TypeLink, Linkname: "../a.txt"}}, true},
+		{"hard link to symlink", []tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"},
+			{Name: "b.txt", Typeflag: tar.TypeLink, Linkname: "link"},
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "tar-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			target := filepath.Join(dir, "target")
+			if err := os.Mkdir(target, 0755); err != nil {
+				t.Fatal(err)
+			}
+			tarball := writeTestTarball(t, dir, test.entries)
+			defer tarball.Close()
+			err = ExtractTarball(tarball, target)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "escape.txt")); err == nil {
+				t.Fatal("File written outside of target")
+			}
+		})
+	}
+}
+
+func writeTestTarball(t *testing.T, dir string, entries []tar.Header) *os.File {
+	f, err := os.Create(filepath.Join(dir, "test.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		entry.Mode = 0644
+		if err := tw.WriteHeader(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}